@@ -6,31 +6,37 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"syscall"
 
 	"github.com/HMasataka/choice/pkg/sfu"
-	"github.com/pion/webrtc/v4"
 )
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
-
 	addr := flag.String("addr", ":8080", "server address")
 	webDir := flag.String("web", "web", "web directory path")
+	configPath := flag.String("config", "config.toml", "config file path")
 	flag.Parse()
 
-	config := sfu.Config{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
+	config, err := sfu.LoadConfig(*configPath)
+	if err != nil {
+		slog.Error("failed to load config", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
-	s := sfu.NewSFU(config)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: config.Log.LogLevel(),
+	}))
+	slog.SetDefault(logger)
+
+	// Allocate a ballast to reduce the GC frequency
+	ballast := make([]byte, config.SFU.Ballast*1024*1024)
+
+	s, err := sfu.NewSFU(config)
+	if err != nil {
+		slog.Error("failed to create sfu", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
 	http.HandleFunc("/ws", s.HandleWebSocket)
 
@@ -64,4 +70,9 @@ func main() {
 	if err := server.Close(); err != nil {
 		slog.Warn("server close error", slog.String("error", err.Error()))
 	}
+	if err := s.Close(); err != nil {
+		slog.Warn("sfu close error", slog.String("error", err.Error()))
+	}
+
+	runtime.KeepAlive(ballast)
 }
@@ -0,0 +1,136 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// Grants are the permissions carried by an access token.
+type Grants struct {
+	// CanPublish allows publishing audio and video tracks.
+	CanPublish bool `json:"canPublish"`
+	// CanSubscribe allows subscribing to other peers and selecting layers.
+	CanSubscribe bool `json:"canSubscribe"`
+	// CanPublishData allows sending data channel messages to the session.
+	CanPublishData bool `json:"canPublishData"`
+	// Admin allows acting on other peers of the session, e.g. removing them with leave.
+	Admin bool `json:"admin"`
+}
+
+// allGrants returns the grants used when authentication is disabled.
+// Admin is never implied, so a connection can only act on the peer it joined as.
+func allGrants() Grants {
+	return Grants{CanPublish: true, CanSubscribe: true, CanPublishData: true}
+}
+
+// Claims are the claims of an access token.
+// The token pins the session and the peer identity the bearer may join as.
+type Claims struct {
+	SessionID string `json:"sessionId"`
+	PeerID    string `json:"peerId"`
+	Grants    Grants `json:"grants"`
+	jwt.RegisteredClaims
+}
+
+// Authentication errors
+var (
+	ErrTokenRequired = errors.New("access token required")
+	ErrTokenInvalid  = errors.New("invalid access token")
+)
+
+// hmacMethods are the accepted signing methods.
+var hmacMethods = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodHS384.Alg(),
+	jwt.SigningMethodHS512.Alg(),
+}
+
+// SignToken signs claims with the HMAC key using HS256.
+func SignToken(key string, claims Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+}
+
+// verifyToken parses and validates an access token signed with the HMAC key.
+func verifyToken(key, token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrTokenRequired
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return []byte(key), nil
+	}, jwt.WithValidMethods(hmacMethods))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenInvalid, err)
+	}
+
+	if claims.SessionID == "" || claims.PeerID == "" {
+		return nil, fmt.Errorf("%w: sessionId and peerId claims are required", ErrTokenInvalid)
+	}
+	return claims, nil
+}
+
+// Grant names used in permission errors
+const (
+	GrantPublish     = "canPublish"
+	GrantSubscribe   = "canSubscribe"
+	GrantPublishData = "canPublishData"
+	GrantAdmin       = "admin"
+)
+
+// Has reports whether the grant with the given name is set.
+// An empty name is always granted.
+func (g Grants) Has(grant string) bool {
+	switch grant {
+	case "":
+		return true
+	case GrantPublish:
+		return g.CanPublish
+	case GrantSubscribe:
+		return g.CanSubscribe
+	case GrantPublishData:
+		return g.CanPublishData
+	case GrantAdmin:
+		return g.Admin
+	default:
+		return false
+	}
+}
+
+// missing returns the name of the first grant of want that g does not have,
+// or an empty string if g has all of them.
+func (g Grants) missing(want Grants) string {
+	for _, grant := range []string{GrantPublish, GrantSubscribe, GrantPublishData, GrantAdmin} {
+		if want.Has(grant) && !g.Has(grant) {
+			return grant
+		}
+	}
+	return ""
+}
+
+// sendsMedia reports whether an offer contains audio or video sections the client sends on.
+func sendsMedia(offer webrtc.SessionDescription) (bool, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return false, err
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		kind := media.MediaName.Media
+		if kind != "audio" && kind != "video" {
+			continue
+		}
+		if _, ok := media.Attribute(sdp.AttrKeyRecvOnly); ok {
+			continue
+		}
+		if _, ok := media.Attribute(sdp.AttrKeyInactive); ok {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,296 @@
+package sfu
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pion/webrtc/v4"
+)
+
+const testKey = "secret"
+
+func testClaims() Claims {
+	return Claims{
+		SessionID: "room",
+		PeerID:    "alice",
+		Grants:    Grants{CanPublish: true, CanSubscribe: true},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func signedToken(t *testing.T, method jwt.SigningMethod, key any, claims Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func TestVerifyToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+
+	expired := testClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noSession := testClaims()
+	noSession.SessionID = ""
+	noPeer := testClaims()
+	noPeer.PeerID = ""
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "valid HS256",
+			token: signedToken(t, jwt.SigningMethodHS256, []byte(testKey), testClaims()),
+		},
+		{
+			name:    "empty",
+			token:   "",
+			wantErr: ErrTokenRequired,
+		},
+		{
+			name:    "wrong key",
+			token:   signedToken(t, jwt.SigningMethodHS256, []byte("other"), testClaims()),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "alg none",
+			token:   signedToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, testClaims()),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "RS256",
+			token:   signedToken(t, jwt.SigningMethodRS256, rsaKey, testClaims()),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "expired",
+			token:   signedToken(t, jwt.SigningMethodHS256, []byte(testKey), expired),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "missing sessionId",
+			token:   signedToken(t, jwt.SigningMethodHS256, []byte(testKey), noSession),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "missing peerId",
+			token:   signedToken(t, jwt.SigningMethodHS256, []byte(testKey), noPeer),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "malformed",
+			token:   "not.a.token",
+			wantErr: ErrTokenInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyToken(testKey, tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if claims != nil {
+					t.Fatalf("claims = %+v, want nil", claims)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.SessionID != "room" || claims.PeerID != "alice" || !claims.Grants.CanPublish {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestSignTokenRoundTrip(t *testing.T) {
+	token, err := SignToken(testKey, testClaims())
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	claims, err := verifyToken(testKey, token)
+	if err != nil {
+		t.Fatalf("verify token: %v", err)
+	}
+	if claims.Grants != testClaims().Grants {
+		t.Fatalf("grants = %+v, want %+v", claims.Grants, testClaims().Grants)
+	}
+}
+
+func TestGrantsHas(t *testing.T) {
+	all := Grants{CanPublish: true, CanSubscribe: true, CanPublishData: true, Admin: true}
+
+	tests := []struct {
+		grant string
+		want  bool
+	}{
+		{"", true},
+		{GrantPublish, true},
+		{GrantSubscribe, true},
+		{GrantPublishData, true},
+		{GrantAdmin, true},
+		{"unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.grant, func(t *testing.T) {
+			if got := all.Has(tt.grant); got != tt.want {
+				t.Errorf("all.Has(%q) = %v, want %v", tt.grant, got, tt.want)
+			}
+			// Only the empty grant is implied without permissions
+			if got := (Grants{}).Has(tt.grant); got != (tt.grant == "") {
+				t.Errorf("Grants{}.Has(%q) = %v", tt.grant, got)
+			}
+		})
+	}
+}
+
+func TestGrantsMissing(t *testing.T) {
+	publisher := Grants{CanPublish: true, CanSubscribe: true, CanPublishData: true}
+
+	tests := []struct {
+		name string
+		have Grants
+		want Grants
+		miss string
+	}{
+		{"same", publisher, publisher, ""},
+		{"fewer wanted", publisher, Grants{CanSubscribe: true}, ""},
+		{"nothing wanted", Grants{}, Grants{}, ""},
+		{"publish revoked", Grants{CanSubscribe: true, CanPublishData: true}, publisher, GrantPublish},
+		{"data revoked", Grants{CanPublish: true, CanSubscribe: true}, publisher, GrantPublishData},
+		{"admin revoked", allGrants(), Grants{Admin: true}, GrantAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.have.missing(tt.want); got != tt.miss {
+				t.Errorf("missing = %q, want %q", got, tt.miss)
+			}
+		})
+	}
+}
+
+func TestAllGrantsExcludeAdmin(t *testing.T) {
+	if allGrants().Admin {
+		t.Fatal("allGrants must not imply admin")
+	}
+}
+
+// testOffer builds an offer with one media section per direction,
+// e.g. "audio sendrecv" or "application".
+func testOffer(sections ...string) webrtc.SessionDescription {
+	var b strings.Builder
+	b.WriteString("v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n")
+	for _, section := range sections {
+		kind, direction, _ := strings.Cut(section, " ")
+		switch kind {
+		case "application":
+			b.WriteString("m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n")
+		default:
+			b.WriteString("m=" + kind + " 9 UDP/TLS/RTP/SAVPF 96\r\n")
+		}
+		b.WriteString("c=IN IP4 0.0.0.0\r\n")
+		if direction != "" {
+			b.WriteString("a=" + direction + "\r\n")
+		}
+	}
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: b.String()}
+}
+
+func TestSendsMedia(t *testing.T) {
+	tests := []struct {
+		name  string
+		offer webrtc.SessionDescription
+		want  bool
+	}{
+		{"sendrecv audio", testOffer("audio sendrecv"), true},
+		{"sendonly video", testOffer("video sendonly"), true},
+		{"no direction", testOffer("video"), true},
+		{"recvonly", testOffer("audio recvonly", "video recvonly"), false},
+		{"inactive", testOffer("video inactive"), false},
+		{"data channel only", testOffer("application"), false},
+		{"receive and send", testOffer("audio recvonly", "video sendrecv"), true},
+		{"no media", testOffer(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sendsMedia(tt.offer)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("sendsMedia = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := sendsMedia(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "garbage"}); err == nil {
+		t.Error("expected an error for an invalid offer")
+	}
+}
+
+func TestCheckPublishGrant(t *testing.T) {
+	req := &rpcRequest{ID: 1}
+	publisher := Grants{CanPublish: true, CanSubscribe: true}
+	viewer := Grants{CanSubscribe: true}
+
+	tests := []struct {
+		name     string
+		grants   Grants
+		offer    webrtc.SessionDescription
+		wantCode int
+	}{
+		{"publisher sends media", publisher, testOffer("audio sendrecv", "video sendonly"), 0},
+		{"publisher receives only", publisher, testOffer("video recvonly"), 0},
+		{"viewer sends media", viewer, testOffer("audio sendrecv"), JSONRPCForbidden},
+		{"viewer receives only", viewer, testOffer("audio recvonly", "video recvonly"), 0},
+		{"viewer data channel only", viewer, testOffer("application"), 0},
+		{"viewer invalid offer", viewer, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "garbage"}, JSONRPCInvalidParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := checkPublishGrant(req, tt.grants, tt.offer)
+			if tt.wantCode == 0 {
+				if resp != nil {
+					t.Fatalf("unexpected error response: %+v", resp.Error)
+				}
+				return
+			}
+
+			if resp == nil || resp.Error == nil {
+				t.Fatalf("expected error code %d, got nil", tt.wantCode)
+			}
+			if resp.Error.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", resp.Error.Code, tt.wantCode)
+			}
+			if tt.wantCode == JSONRPCForbidden {
+				data, ok := resp.Error.Data.(authErrorData)
+				if !ok || data.Reason != reasonGrantRequired || data.Grant != GrantPublish {
+					t.Fatalf("data = %+v", resp.Error.Data)
+				}
+			}
+		})
+	}
+}
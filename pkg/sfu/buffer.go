@@ -0,0 +1,66 @@
+package sfu
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// packetCache keeps the most recent RTP packets of a layer for retransmission.
+// Packets are stored in a ring indexed by extended sequence number, so a packet
+// is replaced by the one size sequence numbers later, also across wraparound.
+type packetCache struct {
+	packets []*rtp.Packet
+	// last is the extended sequence number of the newest packet
+	last   uint64
+	inited bool
+	mu     sync.RWMutex
+}
+
+func newPacketCache(size int) *packetCache {
+	return &packetCache{packets: make([]*rtp.Packet, size)}
+}
+
+// Add stores a packet. The packet must not be modified afterwards.
+func (c *packetCache) Add(packet *rtp.Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.inited {
+		c.last = initialExtendedSeq(packet.SequenceNumber)
+		c.inited = true
+	}
+
+	ext := unwrapSeq(c.last, packet.SequenceNumber)
+	if ext > c.last {
+		c.last = ext
+	}
+	c.packets[ext%uint64(len(c.packets))] = packet
+}
+
+// Get returns the packet with a sequence number, or nil if it is no longer cached.
+func (c *packetCache) Get(seq uint16) *rtp.Packet {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.inited {
+		return nil
+	}
+
+	packet := c.packets[unwrapSeq(c.last, seq)%uint64(len(c.packets))]
+	if packet == nil || packet.SequenceNumber != seq {
+		return nil
+	}
+	return packet
+}
+
+// initialExtendedSeq extends the first sequence number of a stream. It starts
+// one cycle in, so packets reordered before the first one do not underflow.
+func initialExtendedSeq(seq uint16) uint64 {
+	return 1<<16 | uint64(seq)
+}
+
+// unwrapSeq extends seq to the extended sequence number closest to last.
+func unwrapSeq(last uint64, seq uint16) uint64 {
+	return last + uint64(int64(int16(seq-uint16(last))))
+}
@@ -0,0 +1,102 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func testPacket(ssrc uint32, seq uint16, ts uint32) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{SSRC: ssrc, SequenceNumber: seq, Timestamp: ts},
+		Payload: []byte{byte(seq)},
+	}
+}
+
+func TestPacketCacheGet(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		add  []uint16
+		seq  uint16
+		want bool
+	}{
+		{"cached", 8, []uint16{10, 11, 12}, 11, true},
+		{"newest", 8, []uint16{10, 11, 12}, 12, true},
+		{"never added", 8, []uint16{10, 11, 12}, 13, false},
+		{"lost packet", 8, []uint16{10, 12}, 11, false},
+		{"empty cache", 8, nil, 0, false},
+		// 18 takes the slot of 10
+		{"evicted", 8, seqRange(10, 9), 10, false},
+		{"oldest kept", 8, seqRange(10, 9), 11, true},
+		// 5 maps to the slot that still holds 13
+		{"slot holds other packet", 8, seqRange(10, 4), 5, false},
+		{"before wraparound", 8, seqRange(65532, 8), 65535, true},
+		{"after wraparound", 8, seqRange(65532, 8), 0, true},
+		{"evicted across wraparound", 8, seqRange(65530, 10), 65531, false},
+		{"oldest kept across wraparound", 8, seqRange(65530, 10), 65532, true},
+		// 500 does not divide 65536, so the slots are not aligned with seq%size
+		{"unaligned size before wraparound", 500, seqRange(65500, 100), 65500, true},
+		{"unaligned size after wraparound", 500, seqRange(65500, 100), 63, true},
+		{"unaligned size evicted", 500, seqRange(65000, 600), 65099, false},
+		{"unaligned size oldest kept", 500, seqRange(65000, 600), 65100, true},
+		{"reordered", 8, []uint16{10, 12, 11}, 11, true},
+		{"reordered before first", 8, []uint16{1, 0, 65535}, 65535, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newPacketCache(tt.size)
+			for _, seq := range tt.add {
+				cache.Add(testPacket(1, seq, 0))
+			}
+
+			packet := cache.Get(tt.seq)
+			if !tt.want {
+				if packet != nil {
+					t.Fatalf("Get(%d) = packet %d, want nil", tt.seq, packet.SequenceNumber)
+				}
+				return
+			}
+			if packet == nil || packet.SequenceNumber != tt.seq {
+				t.Fatalf("Get(%d) = %v, want packet %d", tt.seq, packet, tt.seq)
+			}
+		})
+	}
+}
+
+// seqRange returns n sequence numbers from first, wrapping after 65535.
+func seqRange(first uint16, n int) []uint16 {
+	seqs := make([]uint16, n)
+	for i := range seqs {
+		seqs[i] = first + uint16(i)
+	}
+	return seqs
+}
+
+func TestUnwrapSeq(t *testing.T) {
+	const cycle = 1 << 16
+
+	tests := []struct {
+		name string
+		last uint64
+		seq  uint16
+		want uint64
+	}{
+		{"same", cycle + 100, 100, cycle + 100},
+		{"newer", cycle + 100, 105, cycle + 105},
+		{"older", cycle + 100, 95, cycle + 95},
+		{"newer across wraparound", cycle + 65535, 2, 2*cycle + 2},
+		{"older across wraparound", 2*cycle + 2, 65535, cycle + 65535},
+		{"half a cycle newer", cycle, 32767, cycle + 32767},
+		{"half a cycle older", cycle, 32768, cycle - 32768},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unwrapSeq(tt.last, tt.seq); got != tt.want {
+				t.Errorf("unwrapSeq(%d, %d) = %d, want %d", tt.last, tt.seq, got, tt.want)
+			}
+		})
+	}
+}
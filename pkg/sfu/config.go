@@ -0,0 +1,396 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+// Config holds the SFU configuration.
+// It mirrors the sections of config.toml.
+type Config struct {
+	SFU     SFUConfig     `toml:"sfu"`
+	Router  RouterConfig  `toml:"router"`
+	WebRTC  WebRTCConfig  `toml:"webrtc"`
+	Turn    TurnConfig    `toml:"turn"`
+	Session SessionConfig `toml:"session"`
+	Signal  SignalConfig  `toml:"signal"`
+	Auth    AuthConfig    `toml:"auth"`
+	Log     LogConfig     `toml:"log"`
+
+	// RegisterInterceptors lets embedders add their own interceptors to the
+	// webrtc.API after the configured ones. It is not read from config.toml.
+	RegisterInterceptors func(*webrtc.MediaEngine, *interceptor.Registry) error `toml:"-"`
+}
+
+// SFUConfig holds process level settings.
+type SFUConfig struct {
+	// Ballast is the size in MiB of memory allocated up front to reduce GC frequency.
+	Ballast int64 `toml:"ballast"`
+	// WithStats enables Prometheus statistics. They are not implemented, so
+	// Validate rejects it.
+	WithStats bool `toml:"withstats"`
+}
+
+// RouterConfig holds media routing settings.
+type RouterConfig struct {
+	// MaxBandwidth limits the REMB bandwidth sent to publishers in kbps. Zero means no limit.
+	MaxBandwidth uint64 `toml:"maxbandwidth"`
+	// MaxPacketTrack is the number of video packets kept per layer for retransmission.
+	MaxPacketTrack int `toml:"maxpackettrack"`
+	// SelfSubscribe allows a peer to receive its own published media.
+	SelfSubscribe bool `toml:"selfsubscribe"`
+	// AudioLevelThreshold, AudioLevelInterval and AudioLevelFilter configure active
+	// speaker detection. It is not implemented, so Validate rejects non-zero values.
+	AudioLevelThreshold uint8 `toml:"audiolevelthreshold"`
+	AudioLevelInterval  int   `toml:"audiolevelinterval"`
+	AudioLevelFilter    int   `toml:"audiolevelfilter"`
+	// Simulcast holds simulcast layer selection settings.
+	Simulcast SimulcastConfig `toml:"simulcast"`
+}
+
+// SimulcastConfig holds simulcast layer selection settings.
+type SimulcastConfig struct {
+	// BestQualityFirst starts new subscribers on the best available layer instead of mid.
+	BestQualityFirst bool `toml:"bestqualityfirst"`
+	// EnableTemporalLayer enables temporal layer switching. It is not implemented,
+	// so Validate rejects it.
+	EnableTemporalLayer bool `toml:"enabletemporallayer"`
+}
+
+// WebRTCConfig holds WebRTC transport settings.
+type WebRTCConfig struct {
+	// SinglePort multiplexes all ICE UDP traffic over one port. PortRange is ignored when set.
+	SinglePort int `toml:"singleport"`
+	// PortRange is the [min, max] UDP port range used for ICE.
+	PortRange []uint16 `toml:"portrange"`
+	// TCPPort is the passive ICE-TCP port shared by all peer connections. Zero disables ICE-TCP.
+	TCPPort int `toml:"tcpport"`
+	// ICEServers are handed out to every peer connection.
+	ICEServers []ICEServerConfig `toml:"iceserver"`
+	// SDPSemantics is one of "unified-plan", "plan-b" or "unified-plan-with-fallback".
+	SDPSemantics string `toml:"sdpsemantics"`
+	// MDNS toggles multicast DNS candidates.
+	MDNS bool `toml:"mdns"`
+	// Candidates holds candidate gathering settings.
+	Candidates CandidatesConfig `toml:"candidates"`
+	// Timeouts holds ICE and connect timeouts.
+	Timeouts ICETimeoutsConfig `toml:"timeouts"`
+	// Interceptors selects the RTP/RTCP interceptors registered on every peer connection.
+	Interceptors InterceptorsConfig `toml:"interceptors"`
+}
+
+// ICEServerConfig describes a STUN or TURN server.
+type ICEServerConfig struct {
+	URLs       []string `toml:"urls"`
+	Username   string   `toml:"username"`
+	Credential string   `toml:"credential"`
+}
+
+// CandidatesConfig holds candidate gathering settings.
+type CandidatesConfig struct {
+	// NAT1To1 replaces host candidate addresses with these public IPs.
+	NAT1To1 []string `toml:"nat1to1"`
+	// ICELite runs the ICE agent in lite mode.
+	ICELite bool `toml:"icelite"`
+}
+
+// ICETimeoutsConfig holds ICE timeouts in seconds. Zero uses the pion default.
+type ICETimeoutsConfig struct {
+	Disconnected int `toml:"disconnected"`
+	Failed       int `toml:"failed"`
+	Keepalive    int `toml:"keepalive"`
+	// PublisherConnect and SubscriberConnect are the times a connection has to
+	// connect after its first offer before the peer is removed. Zero disables them.
+	PublisherConnect  int `toml:"publisherconnect"`
+	SubscriberConnect int `toml:"subscriberconnect"`
+}
+
+// InterceptorsConfig selects the RTP/RTCP interceptors of the webrtc.API.
+type InterceptorsConfig struct {
+	// RTCPReports generates receiver reports for publishers and sender reports for subscribers.
+	RTCPReports bool `toml:"rtcpreports"`
+	// NACKGenerator sends NACKs to publishers for lost packets. Without it, the
+	// SFU NACKs the lost packets subscribers ask for instead.
+	NACKGenerator bool `toml:"nackgenerator"`
+	// NACKResponder answers NACKs from its own send buffer. The SFU already answers
+	// subscriber NACKs from the publisher's packet cache, so it is off by default.
+	NACKResponder bool `toml:"nackresponder"`
+	// TWCC negotiates the transport-wide-cc header extension, sends TWCC feedback
+	// to publishers for their bandwidth estimation, and numbers the packets sent
+	// to subscribers.
+	TWCC bool `toml:"twcc"`
+}
+
+// TurnConfig holds the embedded TURN server settings.
+type TurnConfig struct {
+	Enabled   bool           `toml:"enabled"`
+	Realm     string         `toml:"realm"`
+	Address   string         `toml:"address"`
+	Cert      string         `toml:"cert"`
+	Key       string         `toml:"key"`
+	PortRange []uint16       `toml:"portrange"`
+	Auth      TurnAuthConfig `toml:"auth"`
+}
+
+// TurnAuthConfig holds TURN credentials.
+type TurnAuthConfig struct {
+	// Secret is used to generate long-term credentials. Takes precedence over Credentials.
+	Secret string `toml:"secret"`
+	// Credentials is a comma separated list of user=password pairs.
+	Credentials string `toml:"credentials"`
+	// ShareCredentials hands the first static credential to clients when no
+	// secret is set. Every client then learns the same password.
+	ShareCredentials bool `toml:"sharecredentials"`
+}
+
+// SessionConfig holds session lifetime settings.
+type SessionConfig struct {
+	// EmptyTimeout is how long in seconds a session is kept after its last peer left.
+	// Zero closes it as soon as it is empty.
+	EmptyTimeout int `toml:"emptytimeout"`
+	// MaxDuration is the maximum lifetime of a session in seconds. Zero means no limit.
+	MaxDuration int `toml:"maxduration"`
+	// AutoSubscribe subscribes joining peers to all current and future routers
+	// of the session. A join can override it.
+	AutoSubscribe bool `toml:"autosubscribe"`
+}
+
+// SignalConfig holds signaling settings.
+type SignalConfig struct {
+	// DisconnectGrace is how long in seconds a peer is kept after its WebSocket dropped
+	// before it is removed from the session. Zero removes it immediately.
+	DisconnectGrace int `toml:"disconnectgrace"`
+	// RequestTimeout is how long in seconds the server waits for the client to
+	// respond to a request, such as an offer, before sending it again.
+	RequestTimeout int `toml:"requesttimeout"`
+	// RequestRetries is how many times a request is sent again after a timeout.
+	RequestRetries int `toml:"requestretries"`
+}
+
+// AuthConfig holds signaling authentication settings.
+type AuthConfig struct {
+	// Enabled requires an access token to join a session.
+	Enabled bool `toml:"enabled"`
+	// Key is the HMAC key access tokens are signed with (HS256, HS384 or HS512).
+	Key string `toml:"key"`
+}
+
+// LogConfig holds logging settings.
+type LogConfig struct {
+	// V is the verbosity: 0 - INFO, 1 - DEBUG, 2 - TRACE.
+	V int `toml:"v"`
+}
+
+// LevelTrace is the slog level used for trace logging.
+const LevelTrace = slog.LevelDebug - 4
+
+// SDP semantics values
+const (
+	SDPSemanticsUnifiedPlan             = "unified-plan"
+	SDPSemanticsPlanB                   = "plan-b"
+	SDPSemanticsUnifiedPlanWithFallback = "unified-plan-with-fallback"
+)
+
+// minPortRangeSize is the minimum number of ports in a configured port range.
+const minPortRangeSize = 100
+
+// DefaultConfig returns the default configuration.
+func DefaultConfig() Config {
+	return Config{
+		Router: RouterConfig{
+			MaxPacketTrack: 500,
+		},
+		WebRTC: WebRTCConfig{
+			SDPSemantics: SDPSemanticsUnifiedPlan,
+			MDNS:         true,
+			Timeouts: ICETimeoutsConfig{
+				PublisherConnect:  30,
+				SubscriberConnect: 30,
+			},
+			Interceptors: InterceptorsConfig{
+				RTCPReports:   true,
+				NACKGenerator: true,
+				TWCC:          true,
+			},
+		},
+		Session: SessionConfig{
+			EmptyTimeout: 30,
+		},
+		Signal: SignalConfig{
+			DisconnectGrace: 10,
+			RequestTimeout:  10,
+			RequestRetries:  2,
+		},
+	}
+}
+
+// LoadConfig reads a TOML configuration file on top of DefaultConfig.
+// Unknown keys and invalid values are rejected.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+
+	decoder := toml.NewDecoder(f).DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) {
+			keys := make([]string, 0, len(strictErr.Errors))
+			for _, keyErr := range strictErr.Errors {
+				keys = append(keys, strings.Join(keyErr.Key(), "."))
+			}
+			return Config{}, fmt.Errorf("config %s: unknown keys: %s", path, strings.Join(keys, ", "))
+		}
+		return Config{}, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Validate checks the configuration for invalid values.
+func (c Config) Validate() error {
+	if c.SFU.Ballast < 0 {
+		return errors.New("sfu.ballast must not be negative")
+	}
+	if c.SFU.WithStats {
+		return errors.New("sfu.withstats is not supported")
+	}
+
+	if c.Router.MaxPacketTrack < 0 {
+		return errors.New("router.maxpackettrack must not be negative")
+	}
+	if c.Router.AudioLevelThreshold != 0 || c.Router.AudioLevelInterval != 0 || c.Router.AudioLevelFilter != 0 {
+		return errors.New("router.audiolevelthreshold, audiolevelinterval and audiolevelfilter are not supported")
+	}
+	if c.Router.Simulcast.EnableTemporalLayer {
+		return errors.New("router.simulcast.enabletemporallayer is not supported")
+	}
+
+	if c.WebRTC.SinglePort < 0 || c.WebRTC.SinglePort > 65535 {
+		return errors.New("webrtc.singleport must be in [0, 65535]")
+	}
+	if err := validatePortRange("webrtc.portrange", c.WebRTC.PortRange); err != nil {
+		return err
+	}
+	if c.WebRTC.TCPPort < 0 || c.WebRTC.TCPPort > 65535 {
+		return errors.New("webrtc.tcpport must be in [0, 65535]")
+	}
+	for i, server := range c.WebRTC.ICEServers {
+		if len(server.URLs) == 0 {
+			return fmt.Errorf("webrtc.iceserver[%d].urls must not be empty", i)
+		}
+	}
+	switch c.WebRTC.SDPSemantics {
+	case "", SDPSemanticsUnifiedPlan, SDPSemanticsPlanB, SDPSemanticsUnifiedPlanWithFallback:
+	default:
+		return fmt.Errorf("webrtc.sdpsemantics: unknown value %q", c.WebRTC.SDPSemantics)
+	}
+	for _, ip := range c.WebRTC.Candidates.NAT1To1 {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("webrtc.candidates.nat1to1: invalid IP %q", ip)
+		}
+	}
+	timeouts := c.WebRTC.Timeouts
+	if timeouts.Disconnected < 0 || timeouts.Failed < 0 || timeouts.Keepalive < 0 ||
+		timeouts.PublisherConnect < 0 || timeouts.SubscriberConnect < 0 {
+		return errors.New("webrtc.timeouts must not be negative")
+	}
+
+	if err := c.Turn.validate(); err != nil {
+		return err
+	}
+
+	if c.Session.EmptyTimeout < 0 {
+		return errors.New("session.emptytimeout must not be negative")
+	}
+	if c.Session.MaxDuration < 0 {
+		return errors.New("session.maxduration must not be negative")
+	}
+
+	if c.Signal.DisconnectGrace < 0 {
+		return errors.New("signal.disconnectgrace must not be negative")
+	}
+	if c.Signal.RequestTimeout <= 0 {
+		return errors.New("signal.requesttimeout must be positive")
+	}
+	if c.Signal.RequestRetries < 0 {
+		return errors.New("signal.requestretries must not be negative")
+	}
+
+	if c.Auth.Enabled && c.Auth.Key == "" {
+		return errors.New("auth.key must not be empty")
+	}
+
+	if c.Log.V < 0 || c.Log.V > 2 {
+		return errors.New("log.v must be in [0, 2]")
+	}
+	return nil
+}
+
+// LogLevel returns the slog level for the configured verbosity.
+func (c LogConfig) LogLevel() slog.Level {
+	switch c.V {
+	case 1:
+		return slog.LevelDebug
+	case 2:
+		return LevelTrace
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (c TurnConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Realm == "" {
+		return errors.New("turn.realm must not be empty")
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("turn.address: %w", err)
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		return errors.New("turn.cert and turn.key must be set together")
+	}
+	if len(c.PortRange) != 0 && (len(c.PortRange) != 2 || c.PortRange[0] == 0 || c.PortRange[1] < c.PortRange[0]) {
+		return errors.New("turn.portrange must be [min, max]")
+	}
+
+	credentials, err := parseTurnCredentials(c.Auth.Credentials)
+	if err != nil {
+		return err
+	}
+	if c.Auth.Secret == "" && len(credentials) == 0 {
+		return errors.New("turn.auth: secret or credentials must be set")
+	}
+	return nil
+}
+
+func validatePortRange(key string, portRange []uint16) error {
+	if len(portRange) == 0 {
+		return nil
+	}
+	if len(portRange) != 2 {
+		return fmt.Errorf("%s must be [min, max]", key)
+	}
+	if portRange[0] == 0 || portRange[1] < portRange[0] || portRange[1]-portRange[0] < minPortRangeSize {
+		return fmt.Errorf("%s: max - min must be >= %d", key, minPortRangeSize)
+	}
+	return nil
+}
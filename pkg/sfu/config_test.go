@@ -0,0 +1,122 @@
+package sfu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file to a temporary directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("DefaultConfig().Validate() = %v", err)
+	}
+}
+
+func TestLoadConfigShippedFile(t *testing.T) {
+	if _, err := LoadConfig(filepath.Join("..", "..", "config.toml")); err != nil {
+		t.Fatalf("LoadConfig(config.toml) = %v", err)
+	}
+}
+
+func TestLoadConfigKeepsDefaults(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, "[router]\nselfsubscribe = true\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := DefaultConfig()
+	want.Router.SelfSubscribe = true
+	if config.Router != want.Router || config.Signal != want.Signal || config.Session != want.Session {
+		t.Fatalf("config = %+v, want defaults with selfsubscribe", config)
+	}
+	if !config.WebRTC.MDNS || config.WebRTC.Interceptors != want.WebRTC.Interceptors {
+		t.Fatalf("webrtc = %+v, want defaults", config.WebRTC)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// wantErr is a substring of the error, empty if the config is valid
+		wantErr string
+	}{
+		{"empty file", "", ""},
+		{"known keys", "[sfu]\nballast = 16\n[log]\nv = 1\n", ""},
+		{"unknown key", "[sfu]\nballasst = 16\n", "unknown keys: sfu.ballasst"},
+		{"unknown section", "[metrics]\nenabled = true\n", "unknown keys: metrics"},
+		{"unknown nested key", "[webrtc.timeouts]\nconnect = 5\n", "unknown keys: webrtc.timeouts.connect"},
+		{"wrong type", "[router]\nmaxpackettrack = \"many\"\n", "config"},
+		{"malformed", "[sfu\n", "config"},
+		{"withstats", "[sfu]\nwithstats = true\n", "sfu.withstats is not supported"},
+		{"withstats off", "[sfu]\nwithstats = false\n", ""},
+		{"audio level threshold", "[router]\naudiolevelthreshold = 40\n", "audiolevelthreshold"},
+		{"audio level interval", "[router]\naudiolevelinterval = 1000\n", "audiolevelinterval"},
+		{"audio level filter", "[router]\naudiolevelfilter = 20\n", "audiolevelfilter"},
+		{"temporal layer", "[router.simulcast]\nenabletemporallayer = true\n", "enabletemporallayer is not supported"},
+		{"negative ballast", "[sfu]\nballast = -1\n", "sfu.ballast"},
+		{"negative packet track", "[router]\nmaxpackettrack = -1\n", "router.maxpackettrack"},
+		{"short port range", "[webrtc]\nportrange = [5000, 5010]\n", "webrtc.portrange"},
+		{"port range order", "[webrtc]\nportrange = [6000, 5000]\n", "webrtc.portrange"},
+		{"single port range", "[webrtc]\nsingleport = 70000\n", "webrtc.singleport"},
+		{"empty ice server", "[[webrtc.iceserver]]\nurls = []\n", "webrtc.iceserver[0].urls"},
+		{"sdp semantics", "[webrtc]\nsdpsemantics = \"plan-c\"\n", "webrtc.sdpsemantics"},
+		{"nat1to1", "[webrtc.candidates]\nnat1to1 = [\"example.com\"]\n", "webrtc.candidates.nat1to1"},
+		{"negative timeout", "[webrtc.timeouts]\nsubscriberconnect = -1\n", "webrtc.timeouts"},
+		{"turn without realm", "[turn]\nenabled = true\naddress = \"0.0.0.0:3478\"\n", "turn.realm"},
+		{"turn without auth", "[turn]\nenabled = true\nrealm = \"r\"\naddress = \"0.0.0.0:3478\"\n", "turn.auth"},
+		{"turn disabled", "[turn]\nenabled = false\n", ""},
+		{"request timeout", "[signal]\nrequesttimeout = 0\n", "signal.requesttimeout"},
+		{"auth without key", "[auth]\nenabled = true\n", "auth.key"},
+		{"log level", "[log]\nv = 3\n", "log.v"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.content))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		v    int
+		want string
+	}{
+		{0, "INFO"},
+		{1, "DEBUG"},
+		{2, "DEBUG-4"},
+	}
+
+	for _, tt := range tests {
+		if got := (LogConfig{V: tt.v}).LogLevel().String(); got != tt.want {
+			t.Errorf("LogLevel(%d) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
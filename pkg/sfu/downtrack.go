@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 )
@@ -16,7 +17,9 @@ type DownTrack struct {
 	trackReceiver *TrackReceiver
 	track         *webrtc.TrackLocalStaticRTP
 	sender        *webrtc.RTPSender
+	ssrc          uint32
 	sequencer     *rtpSequencer
+	nackUpstream  bool
 	selector      *LayerSelector
 	codec         string
 	closed        atomic.Bool
@@ -34,26 +37,41 @@ func NewDownTrack(subscriber *Subscriber, trackReceiver *TrackReceiver, codec we
 		return nil, err
 	}
 
-	sender, err := subscriber.pc.AddTrack(track)
+	sender, err := subscriber.addSender(track)
 	if err != nil {
 		return nil, err
 	}
 
-	// Start with mid layer by default
+	encodings := sender.GetParameters().Encodings
+	if len(encodings) == 0 {
+		_ = subscriber.pc.RemoveTrack(sender)
+		return nil, ErrNoSenderEncoding
+	}
+
+	// Start with mid layer by default, or the best layer with bestqualityfirst
 	// Fall back to best available if mid is not available
 	initialLayer := LayerMid
-	if _, ok := trackReceiver.GetLayer(LayerMid); !ok {
+	_, hasMid := trackReceiver.GetLayer(LayerMid)
+	if subscriber.peer.session.sfu.config.Router.Simulcast.BestQualityFirst || !hasMid {
 		if layer := trackReceiver.GetBestLayer(); layer != nil {
 			initialLayer = layer.Name()
 		}
 	}
 
+	// Video packets are remembered for retransmission
+	historySize := 0
+	if trackReceiver.Kind() == webrtc.RTPCodecTypeVideo {
+		historySize = subscriber.peer.session.sfu.config.Router.MaxPacketTrack
+	}
+
 	dt := &DownTrack{
 		subscriber:    subscriber,
 		trackReceiver: trackReceiver,
 		track:         track,
 		sender:        sender,
-		sequencer:     newRTPSequencer(),
+		ssrc:          uint32(encodings[0].SSRC),
+		sequencer:     newRTPSequencer(historySize),
+		nackUpstream:  !subscriber.peer.session.sfu.config.WebRTC.Interceptors.NACKGenerator,
 		selector:      NewLayerSelector(trackReceiver.TrackID(), initialLayer),
 		codec:         codec.MimeType,
 	}
@@ -69,16 +87,100 @@ func NewDownTrack(subscriber *Subscriber, trackReceiver *TrackReceiver, codec we
 	return dt, nil
 }
 
-// readRTCP reads RTCP packets from the sender.
+// readRTCP reads RTCP packets from the sender, forwards the keyframe requests
+// of the subscriber to the publisher and answers its NACKs.
 func (d *DownTrack) readRTCP() {
 	for {
 		if d.closed.Load() {
 			return
 		}
-		if _, _, err := d.sender.ReadRTCP(); err != nil {
+		packets, _, err := d.sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				d.forwardKeyframeRequest()
+			case *rtcp.TransportLayerNack:
+				d.handleNACK(packet)
+			}
+		}
+	}
+}
+
+// forwardKeyframeRequest forwards a keyframe request of the subscriber to the
+// layer it currently receives.
+func (d *DownTrack) forwardKeyframeRequest() {
+	layerName := d.selector.GetCurrentLayer()
+	layer, ok := d.trackReceiver.GetLayer(layerName)
+	if !ok {
+		return
+	}
+
+	if layer.Receiver().RequestKeyframe() {
+		slog.Debug("[DownTrack] Keyframe request forwarded", slog.String("layer", layerName), slog.String("trackID", d.trackReceiver.TrackID()))
+	}
+}
+
+// handleNACK retransmits the packets a subscriber lost from the publisher's
+// packet cache. Packets that are no longer cached are requested from the
+// publisher with a NACK of their own, unless the NACK generator is enabled:
+// it already NACKs every packet the SFU did not receive, and a second NACK
+// would make the publisher retransmit the packet twice.
+func (d *DownTrack) handleNACK(nack *rtcp.TransportLayerNack) {
+	var seqs []uint16
+	for _, pair := range nack.Nacks {
+		seqs = append(seqs, pair.PacketList()...)
+	}
+
+	d.mu.RLock()
+	sent := make([]sentPacket, 0, len(seqs))
+	for _, seq := range seqs {
+		if packet, ok := d.sequencer.Lookup(seq); ok {
+			sent = append(sent, packet)
+		}
+	}
+	d.mu.RUnlock()
+
+	missing := make(map[*LayerReceiver][]uint16)
+	for _, packet := range sent {
+		receiver := d.layerReceiver(packet.sourceSSRC)
+		if receiver == nil {
+			continue
+		}
+
+		cached := receiver.GetPacket(packet.sourceSeq)
+		if cached == nil {
+			if d.nackUpstream {
+				missing[receiver] = append(missing[receiver], packet.sourceSeq)
+			}
+			continue
+		}
+
+		retransmit := cached.Clone()
+		retransmit.SequenceNumber = packet.seq
+		retransmit.Timestamp = cached.Timestamp + packet.tsOffset
+		retransmit.SSRC = d.ssrc
+		if err := d.track.WriteRTP(retransmit); err != nil {
+			slog.Debug("[DownTrack] Retransmission failed", slog.String("trackID", d.trackReceiver.TrackID()), slog.String("error", err.Error()))
 			return
 		}
 	}
+
+	for receiver, seqs := range missing {
+		receiver.SendNACK(seqs)
+	}
+}
+
+// layerReceiver returns the receiver of the layer with an SSRC.
+func (d *DownTrack) layerReceiver(ssrc uint32) *LayerReceiver {
+	for _, layer := range d.trackReceiver.GetLayers() {
+		if uint32(layer.SSRC()) == ssrc {
+			return layer.Receiver()
+		}
+	}
+	return nil
 }
 
 // requestInitialKeyframe requests keyframes with retry.
@@ -132,8 +234,7 @@ func (d *DownTrack) WriteRTP(packet *rtp.Packet, fromLayer string) error {
 		return nil
 	}
 
-	ssrc := uint32(d.sender.GetParameters().Encodings[0].SSRC)
-	rewritten := d.sequencer.Rewrite(packet, ssrc)
+	rewritten := d.sequencer.Rewrite(packet, d.ssrc)
 
 	return d.track.WriteRTP(rewritten)
 }
@@ -230,20 +331,20 @@ func (d *DownTrack) TrackReceiver() *TrackReceiver {
 	return d.trackReceiver
 }
 
-// Close closes the downtrack.
+// Close closes the downtrack and removes it from the subscriber connection.
 func (d *DownTrack) Close() error {
 	if d.closed.Swap(true) {
 		return nil
 	}
 
 	d.mu.Lock()
-	defer d.mu.Unlock()
-
 	if d.trackReceiver != nil {
 		d.trackReceiver.RemoveDownTrack(d)
 	}
+	d.mu.Unlock()
 
 	if d.subscriber != nil && d.sender != nil {
+		return d.subscriber.removeDownTrack(d)
 	}
 
 	return nil
@@ -55,6 +55,11 @@ func (f *Forwarder) Forward(packet *rtp.Packet, fromLayer string) {
 	f.mu.RUnlock()
 
 	for _, dt := range downTracks {
+		if dt.closed.Load() {
+			// Closed along with its subscriber
+			f.RemoveDownTrack(dt)
+			continue
+		}
 		if err := dt.WriteRTP(packet, fromLayer); err != nil {
 			slog.Debug("downtrack write error (forwarder)", slog.String("error", err.Error()))
 			f.RemoveDownTrack(dt)
@@ -65,15 +70,21 @@ func (f *Forwarder) Forward(packet *rtp.Packet, fromLayer string) {
 // Close closes the forwarder and all its downtracks.
 func (f *Forwarder) Close() {
 	f.mu.Lock()
-	defer f.mu.Unlock()
-
 	if f.closed {
+		f.mu.Unlock()
 		return
 	}
 	f.closed = true
 	close(f.closeCh)
 
+	downTracks := make([]*DownTrack, 0, len(f.downTracks))
 	for dt := range f.downTracks {
+		downTracks = append(downTracks, dt)
+	}
+	f.downTracks = make(map[*DownTrack]struct{})
+	f.mu.Unlock()
+
+	for _, dt := range downTracks {
 		if err := dt.Close(); err != nil {
 			slog.Debug("downtrack close error (forwarder)", slog.String("error", err.Error()))
 		}
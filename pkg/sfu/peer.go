@@ -1,49 +1,201 @@
 package sfu
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v4"
 )
 
+// maxNotificationHistory is the number of sent notifications kept per peer for replay on resume.
+const maxNotificationHistory = 256
+
+// sentNotification is a notification kept for replay.
+type sentNotification struct {
+	seq  uint64
+	data []byte
+}
+
+// Role declares which connections a peer needs when it joins.
+type Role string
+
+const (
+	// RoleBoth publishes and subscribes.
+	RoleBoth Role = "both"
+	// RolePublisher only publishes, e.g. an ingest bot.
+	RolePublisher Role = "publisher"
+	// RoleSubscriber only subscribes, e.g. a viewer.
+	RoleSubscriber Role = "subscriber"
+)
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return r == RoleBoth || r == RolePublisher || r == RoleSubscriber
+}
+
+func (r Role) publishes() bool {
+	return r != RoleSubscriber
+}
+
+func (r Role) subscribes() bool {
+	return r != RolePublisher
+}
+
+// Mode declares how many peer connections a peer uses.
+type Mode string
+
+const (
+	// ModeDual uses a publisher and a subscriber connection.
+	ModeDual Mode = "dual"
+	// ModeSingle carries both directions on one connection: recvonly
+	// transceivers for published media and sendonly ones for subscribed media.
+	// The client offers first, and on glare the server keeps its offer.
+	ModeSingle Mode = "single"
+)
+
+// Valid reports whether m is a known mode.
+func (m Mode) Valid() bool {
+	return m == ModeDual || m == ModeSingle
+}
+
 // Peer represents a client connected to the SFU.
 // It manages both publishing (sending media) and subscribing (receiving media) connections.
+// A connection is created when the peer's role needs it, or on first use, so that
+// a viewer can start publishing later. In single mode both use one connection,
+// which is created at join.
 type Peer struct {
 	id         string
 	session    *Session
 	publisher  *Publisher
 	subscriber *Subscriber
 	conn       *wsConn
+	grants     Grants
+	role       Role
+	mode       Mode
 	mu         sync.RWMutex
-	closed     bool
+	// writeMu serializes writes to conn, so that they leave mu free and keep
+	// notifications in sequence order
+	writeMu sync.Mutex
+	closed  bool
+	// removeTimer removes the peer once the disconnect grace period after losing conn ends
+	removeTimer *time.Timer
+	// resumeToken lets a reconnecting client reattach to this peer
+	resumeToken string
+	// seq is the sequence number of the last notification
+	seq uint64
+	// detachedSeq is seq at the time conn was lost
+	detachedSeq uint64
+	// written is the sequence number of the last notification written to conn.
+	// It is guarded by writeMu.
+	written uint64
+	history []sentNotification
+	// autoSubscribe subscribes the peer to every router of its session
+	autoSubscribe atomic.Bool
+	// requestID is the ID of the last request sent to the client
+	requestID uint64
+	// requests are the requests waiting for a response from the client
+	requests map[uint64]chan *rpcClientResponse
+	// done is closed when the peer is closed
+	done chan struct{}
 }
 
-func newPeer(id string, session *Session, conn *wsConn) (*Peer, error) {
+func newPeer(id string, session *Session, conn *wsConn, grants Grants, role Role, mode Mode) (*Peer, error) {
+	resumeToken, err := newResumeToken()
+	if err != nil {
+		return nil, err
+	}
+
 	p := &Peer{
-		id:      id,
-		session: session,
-		conn:    conn,
+		id:          id,
+		session:     session,
+		conn:        conn,
+		grants:      grants,
+		role:        role,
+		mode:        mode,
+		resumeToken: resumeToken,
+		requests:    make(map[uint64]chan *rpcClientResponse),
+		done:        make(chan struct{}),
+	}
+
+	if mode == ModeSingle {
+		return p, p.newSharedConnection()
+	}
+
+	if role.publishes() {
+		publisher, err := p.newPublisher()
+		if err != nil {
+			return nil, err
+		}
+		p.publisher = publisher
+	}
+
+	if role.subscribes() {
+		subscriber, err := p.newSubscriber()
+		if err != nil {
+			if p.publisher != nil {
+				if err := p.publisher.Close(); err != nil {
+					slog.Warn("publisher close error", slog.String("error", err.Error()))
+				}
+			}
+			return nil, err
+		}
+		p.subscriber = subscriber
 	}
 
-	publisher, err := newPublisher(p)
+	return p, nil
+}
+
+// newSharedConnection creates the publisher and the subscriber on one connection.
+func (p *Peer) newSharedConnection() error {
+	pc, err := newPeerConnection(p.session.sfu, true)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	p.publisher = publisher
 
-	subscriber, err := newSubscriber(p)
+	publisher, err := newPublisher(p, pc)
+	if err != nil {
+		if err := pc.Close(); err != nil {
+			slog.Warn("peer connection close error", slog.String("error", err.Error()))
+		}
+		return err
+	}
+	subscriber, err := newSubscriber(p, pc)
 	if err != nil {
 		if err := publisher.Close(); err != nil {
 			slog.Warn("publisher close error", slog.String("error", err.Error()))
 		}
-		return nil, err
+		return err
 	}
+
+	p.publisher = publisher
 	p.subscriber = subscriber
+	return nil
+}
 
-	return p, nil
+// newPublisher creates a publisher on a connection of its own.
+func (p *Peer) newPublisher() (*Publisher, error) {
+	pc, err := newPeerConnection(p.session.sfu, false)
+	if err != nil {
+		return nil, err
+	}
+	return newPublisher(p, pc)
+}
+
+// newSubscriber creates a subscriber on a connection of its own.
+func (p *Peer) newSubscriber() (*Subscriber, error) {
+	pc, err := newPeerConnection(p.session.sfu, false)
+	if err != nil {
+		return nil, err
+	}
+	return newSubscriber(p, pc)
 }
 
 // ID returns the peer identifier.
@@ -56,77 +208,451 @@ func (p *Peer) Session() *Session {
 	return p.session
 }
 
+// ResumeToken returns the token a reconnecting client presents to resume this peer.
+func (p *Peer) ResumeToken() string {
+	return p.resumeToken
+}
+
+// Grants returns the permissions the peer joined with.
+func (p *Peer) Grants() Grants {
+	return p.grants
+}
+
+// Role returns the role the peer joined with.
+func (p *Peer) Role() Role {
+	return p.role
+}
+
+// Mode returns the connection mode the peer joined with.
+func (p *Peer) Mode() Mode {
+	return p.mode
+}
+
+// getPublisher returns the publisher, or nil if it has not been created.
+func (p *Peer) getPublisher() *Publisher {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.publisher
+}
+
+// getSubscriber returns the subscriber, or nil if it has not been created.
+func (p *Peer) getSubscriber() *Subscriber {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.subscriber
+}
+
+// ensurePublisher returns the publisher, creating it on first use.
+func (p *Peer) ensurePublisher() (*Publisher, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPeerNotFound
+	}
+	if p.publisher == nil {
+		publisher, err := p.newPublisher()
+		if err != nil {
+			return nil, err
+		}
+		p.publisher = publisher
+		slog.Info("publisher connection created", slog.String("peerID", p.id))
+	}
+	return p.publisher, nil
+}
+
+// ensureSubscriber returns the subscriber, creating it on first use.
+func (p *Peer) ensureSubscriber() (*Subscriber, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPeerNotFound
+	}
+	if p.subscriber == nil {
+		subscriber, err := p.newSubscriber()
+		if err != nil {
+			return nil, err
+		}
+		p.subscriber = subscriber
+		slog.Info("subscriber connection created", slog.String("peerID", p.id))
+	}
+	return p.subscriber, nil
+}
+
 // WebRTC Operations
 
 // HandleOffer processes an SDP offer from the client and returns an answer.
+// The first offer of a subscribe-only peer creates its publisher connection.
 func (p *Peer) HandleOffer(offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
-	return p.publisher.HandleOffer(offer)
+	publisher, err := p.ensurePublisher()
+	if err != nil {
+		return nil, err
+	}
+	return publisher.HandleOffer(offer)
 }
 
 // HandleAnswer processes an SDP answer from the client for the subscriber connection.
 func (p *Peer) HandleAnswer(answer webrtc.SessionDescription) error {
-	return p.subscriber.HandleAnswer(answer)
+	subscriber := p.getSubscriber()
+	if subscriber == nil {
+		return ErrNoSubscriber
+	}
+	return subscriber.HandleAnswer(answer)
 }
 
 // AddICECandidate adds an ICE candidate to the appropriate connection.
+// In single mode the target is ignored.
 func (p *Peer) AddICECandidate(candidate webrtc.ICECandidateInit, target string) error {
-	if target == "subscriber" {
-		return p.subscriber.AddICECandidate(candidate)
+	if target == "subscriber" && p.mode != ModeSingle {
+		subscriber := p.getSubscriber()
+		if subscriber == nil {
+			return ErrNoSubscriber
+		}
+		return subscriber.AddICECandidate(candidate)
+	}
+
+	publisher := p.getPublisher()
+	if publisher == nil {
+		return ErrNoPublisher
 	}
-	return p.publisher.AddICECandidate(candidate)
+	return publisher.AddICECandidate(candidate)
 }
 
 // Subscribe subscribes to a router to receive its media tracks.
+// The first subscription of a publish-only peer creates its subscriber connection.
 func (p *Peer) Subscribe(router *Router) error {
-	return p.subscriber.Subscribe(router)
+	subscriber, err := p.ensureSubscriber()
+	if err != nil {
+		return err
+	}
+	return subscriber.Subscribe(router)
+}
+
+// SubscribeTracks subscribes to specific tracks of a router.
+func (p *Peer) SubscribeTracks(router *Router, trackIDs []string) error {
+	subscriber, err := p.ensureSubscriber()
+	if err != nil {
+		return err
+	}
+	return subscriber.SubscribeTracks(router, trackIDs)
+}
+
+// Unsubscribe unsubscribes from a router and stops receiving its tracks.
+func (p *Peer) Unsubscribe(router *Router) {
+	if subscriber := p.getSubscriber(); subscriber != nil {
+		subscriber.Unsubscribe(router)
+	}
+}
+
+// UnsubscribeTracks stops receiving specific tracks of a router.
+func (p *Peer) UnsubscribeTracks(router *Router, trackIDs []string) error {
+	subscriber := p.getSubscriber()
+	if subscriber == nil {
+		return nil
+	}
+	return subscriber.UnsubscribeTracks(router, trackIDs)
 }
 
 // SetLayer sets the target layer for a track.
 func (p *Peer) SetLayer(trackID, layer string) {
-	p.subscriber.SetLayer(trackID, layer)
+	if subscriber := p.getSubscriber(); subscriber != nil {
+		subscriber.SetLayer(trackID, layer)
+	}
 }
 
 // GetLayer returns the current and target layer for a track.
 func (p *Peer) GetLayer(trackID string) (current, target string, ok bool) {
-	return p.subscriber.GetLayer(trackID)
+	subscriber := p.getSubscriber()
+	if subscriber == nil {
+		return "", "", false
+	}
+	return subscriber.GetLayer(trackID)
 }
 
 // Signaling
 
+// detach drops conn as the peer's signaling connection and schedules remove after grace.
+// It returns false if the peer is attached to another connection.
+func (p *Peer) detach(conn *wsConn, grace time.Duration, remove func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || p.conn != conn {
+		return false
+	}
+	p.conn = nil
+	p.detachedSeq = p.seq
+	p.removeTimer = time.AfterFunc(grace, remove)
+	return true
+}
+
+// resume attaches conn as the peer's signaling connection, writes response to it and
+// replays the notifications sent after lastSeq. A zero lastSeq replays the notifications
+// sent since the previous connection was lost. A previous connection that is still
+// open is closed.
+func (p *Peer) resume(conn *wsConn, token string, lastSeq uint64, response []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPeerNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.resumeToken)) != 1 {
+		p.mu.Unlock()
+		return ErrResumeTokenInvalid
+	}
+
+	if lastSeq == 0 {
+		lastSeq = p.seq
+		if p.conn == nil {
+			lastSeq = p.detachedSeq
+		}
+	}
+	if lastSeq > p.seq {
+		p.mu.Unlock()
+		return ErrReplayUnavailable
+	}
+	if lastSeq < p.seq && (len(p.history) == 0 || p.history[0].seq > lastSeq+1) {
+		p.mu.Unlock()
+		return ErrReplayUnavailable
+	}
+
+	if p.removeTimer != nil {
+		p.removeTimer.Stop()
+		p.removeTimer = nil
+	}
+	previous := p.conn
+	p.conn = conn
+
+	replay := [][]byte{response}
+	for _, n := range p.history {
+		if n.seq > lastSeq {
+			replay = append(replay, n.data)
+		}
+	}
+	p.written = p.seq
+	subscriber := p.subscriber
+	p.mu.Unlock()
+
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			slog.Warn("ws conn close error", slog.String("error", err.Error()))
+		}
+	}
+
+	for _, data := range replay {
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+	}
+
+	// An offer that could not be sent while disconnected is sent now
+	if subscriber != nil {
+		go subscriber.resumeNegotiation()
+	}
+	return nil
+}
+
+// unbind clears the signaling connection without closing it, so that closing
+// the peer leaves the connection open.
+func (p *Peer) unbind() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn = nil
+}
+
+// isClosed reports whether the peer was closed, for example when it was removed
+// from its session.
+func (p *Peer) isClosed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.closed
+}
+
+// connected reports whether the peer has a signaling connection.
+func (p *Peer) connected() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.conn != nil
+}
+
 // SendNotification sends a JSON-RPC notification to the client.
+// Each notification carries a sequence number and is kept for replay on resume.
+// While the peer has no signaling connection, notifications are only kept.
 func (p *Peer) SendNotification(method string, params map[string]any) error {
-	p.mu.RLock()
+	p.mu.Lock()
 	if p.closed {
-		p.mu.RUnlock()
+		p.mu.Unlock()
 		return nil
 	}
-	p.mu.RUnlock()
 
+	p.seq++
 	notification := rpcNotification{
 		JSONRPC: "2.0",
 		Method:  method,
 		Params:  params,
+		Seq:     p.seq,
 	}
 
 	data, err := json.Marshal(notification)
 	if err != nil {
+		p.mu.Unlock()
 		return err
 	}
-	return p.conn.WriteMessage(websocket.TextMessage, data)
+
+	p.history = append(p.history, sentNotification{seq: p.seq, data: data})
+	if len(p.history) > maxNotificationHistory {
+		p.history = p.history[len(p.history)-maxNotificationHistory:]
+	}
+	p.mu.Unlock()
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.writePending()
+}
+
+// writePending writes the notifications not yet written to conn, in sequence
+// order. A concurrent sender may already have written them. It must be called
+// with writeMu held.
+func (p *Peer) writePending() error {
+	p.mu.RLock()
+	conn := p.conn
+	var pending [][]byte
+	for _, n := range p.history {
+		if n.seq > p.written {
+			pending = append(pending, n.data)
+		}
+	}
+	seq := p.seq
+	p.mu.RUnlock()
+
+	if conn == nil || len(pending) == 0 {
+		return nil
+	}
+	p.written = seq
+	for _, data := range pending {
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRequest sends a JSON-RPC request to the client. The response is received
+// with awaitResponse. Unlike notifications, requests are not kept for replay:
+// a request sent while the peer has no signaling connection fails with
+// ErrNotConnected.
+func (p *Peer) sendRequest(method string, params map[string]any) (uint64, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0, ErrPeerNotFound
+	}
+	if p.conn == nil {
+		p.mu.Unlock()
+		return 0, ErrNotConnected
+	}
+
+	p.requestID++
+	request := rpcServerRequest{
+		JSONRPC: "2.0",
+		ID:      p.requestID,
+		Method:  method,
+		Params:  params,
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		p.mu.Unlock()
+		return 0, err
+	}
+
+	p.requests[request.ID] = make(chan *rpcClientResponse, 1)
+	conn := p.conn
+	p.mu.Unlock()
+
+	// Notifications sent before the request reach the client first
+	p.writeMu.Lock()
+	err = p.writePending()
+	if err == nil {
+		err = conn.WriteMessage(websocket.TextMessage, data)
+	}
+	p.writeMu.Unlock()
+
+	if err != nil {
+		p.mu.Lock()
+		delete(p.requests, request.ID)
+		p.mu.Unlock()
+		return 0, err
+	}
+	return request.ID, nil
+}
+
+// awaitResponse waits for the response to a request and decodes its result.
+// It fails with ErrRequestTimeout if the client does not respond in time, and
+// with the client's error if the client rejected the request.
+func (p *Peer) awaitResponse(ctx context.Context, id uint64, result any) error {
+	p.mu.RLock()
+	response := p.requests[id]
+	p.mu.RUnlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.requests, id)
+		p.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Duration(p.session.sfu.config.Signal.RequestTimeout) * time.Second)
+	defer timer.Stop()
+
+	select {
+	case resp := <-response:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-timer.C:
+		return ErrRequestTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+		return ErrPeerNotFound
+	}
 }
 
-// SendOffer sends an SDP offer to the client for the subscriber connection.
-func (p *Peer) SendOffer(offer webrtc.SessionDescription) error {
-	return p.SendNotification("offer", map[string]any{"offer": offer})
+// handleResponse delivers a response from the client to the request waiting for it.
+// Responses to requests that timed out are dropped.
+func (p *Peer) handleResponse(resp *rpcClientResponse) {
+	p.mu.RLock()
+	response, ok := p.requests[resp.ID]
+	p.mu.RUnlock()
+
+	if !ok {
+		slog.Debug("response to unknown request dropped", slog.String("peerID", p.id), slog.Uint64("id", resp.ID))
+		return
+	}
+	select {
+	case response <- resp:
+	default:
+	}
 }
 
-// SendCandidate sends an ICE candidate to the client.
+// SendCandidate sends an ICE candidate to the client. A nil candidate, which
+// ends the gathering, is sent as an empty candidate to mark the end of the
+// candidates.
 func (p *Peer) SendCandidate(candidate *webrtc.ICECandidate, target string) error {
-	if candidate == nil {
-		return nil
+	init := webrtc.ICECandidateInit{}
+	if candidate != nil {
+		init = candidate.ToJSON()
 	}
 	return p.SendNotification("candidate", map[string]any{
-		"candidate": candidate.ToJSON(),
+		"candidate": init,
 		"target":    target,
 	})
 }
@@ -134,13 +660,14 @@ func (p *Peer) SendCandidate(candidate *webrtc.ICECandidate, target string) erro
 // SendData sends data to the peer via data channel.
 func (p *Peer) SendData(data []byte) error {
 	p.mu.RLock()
-	if p.closed {
+	if p.closed || p.subscriber == nil {
 		p.mu.RUnlock()
 		return nil
 	}
+	subscriber := p.subscriber
 	p.mu.RUnlock()
 
-	return p.subscriber.SendData(data)
+	return subscriber.SendData(data)
 }
 
 // Close closes the peer and all its connections.
@@ -151,22 +678,37 @@ func (p *Peer) Close() error {
 		return nil
 	}
 	p.closed = true
+	close(p.done)
+	if p.removeTimer != nil {
+		p.removeTimer.Stop()
+	}
+	conn := p.conn
+	publisher := p.publisher
+	subscriber := p.subscriber
 	p.mu.Unlock()
 
-	if p.publisher != nil {
-		if err := p.publisher.Close(); err != nil {
+	if publisher != nil {
+		if err := publisher.Close(); err != nil {
 			slog.Warn("publisher close error", slog.String("error", err.Error()))
 		}
 	}
-	if p.subscriber != nil {
-		if err := p.subscriber.Close(); err != nil {
+	if subscriber != nil {
+		if err := subscriber.Close(); err != nil {
 			slog.Warn("subscriber close error", slog.String("error", err.Error()))
 		}
 	}
-	if p.conn != nil {
-		if err := p.conn.Close(); err != nil {
+	if conn != nil {
+		if err := conn.Close(); err != nil {
 			slog.Warn("ws conn close error", slog.String("error", err.Error()))
 		}
 	}
 	return nil
 }
+
+func newResumeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,181 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendTestNotifications sends n notifications numbered from 1.
+func sendTestNotifications(t *testing.T, peer *Peer, n int) {
+	t.Helper()
+
+	for i := 1; i <= n; i++ {
+		if err := peer.SendNotification("test", map[string]any{"n": i}); err != nil {
+			t.Fatalf("SendNotification: %v", err)
+		}
+	}
+}
+
+func TestPeerResumeReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		lastSeq uint64
+		// sent is the number of notifications sent before the connection is lost
+		sent     int
+		wantSeqs []uint64
+	}{
+		{"from last seq", 2, 5, []uint64{3, 4, 5, 6, 7}},
+		{"since disconnect", 0, 5, []uint64{6, 7}},
+		{"nothing missed", 7, 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, nil)
+			session := s.GetOrCreateSession("room")
+			conn, _ := newTestConn(t)
+			peer := addTestPeer(t, session, "alice", conn)
+
+			sendTestNotifications(t, peer, tt.sent)
+			if !peer.detach(conn, time.Hour, func() {}) {
+				t.Fatal("detach failed")
+			}
+			// Notifications sent while disconnected are only kept
+			sendTestNotifications(t, peer, 2)
+
+			newConn, client := newTestConn(t)
+			if err := peer.resume(newConn, peer.ResumeToken(), tt.lastSeq, []byte(`{"id":1,"result":{}}`)); err != nil {
+				t.Fatalf("resume: %v", err)
+			}
+			if !peer.connected() {
+				t.Fatal("peer not attached to the new connection")
+			}
+
+			if msg := client.read(); msg.ID != 1 {
+				t.Fatalf("first message = %+v, want the resume response", msg)
+			}
+			for _, want := range tt.wantSeqs {
+				if msg := client.read(); msg.Seq != want {
+					t.Fatalf("replayed seq = %d, want %d", msg.Seq, want)
+				}
+			}
+
+			// New notifications continue the sequence
+			sendTestNotifications(t, peer, 1)
+			if msg := client.read(); msg.Seq != 8 {
+				t.Fatalf("seq after resume = %d, want 8", msg.Seq)
+			}
+		})
+	}
+}
+
+func TestPeerResumeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   func(*Peer) string
+		lastSeq uint64
+		sent    int
+		wantErr error
+	}{
+		{"invalid token", func(*Peer) string { return "invalid" }, 0, 1, ErrResumeTokenInvalid},
+		{"seq ahead", (*Peer).ResumeToken, 5, 1, ErrReplayUnavailable},
+		{"history trimmed", (*Peer).ResumeToken, 1, maxNotificationHistory + 10, ErrReplayUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, nil)
+			session := s.GetOrCreateSession("room")
+			conn, _ := newTestConn(t)
+			peer := addTestPeer(t, session, "alice", conn)
+			peer.detach(conn, time.Hour, func() {})
+			sendTestNotifications(t, peer, tt.sent)
+
+			newConn, _ := newTestConn(t)
+			if err := peer.resume(newConn, tt.token(peer), tt.lastSeq, nil); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("resume = %v, want %v", err, tt.wantErr)
+			}
+			if peer.connected() {
+				t.Fatal("failed resume attached the connection")
+			}
+		})
+	}
+}
+
+func TestPeerResumeClosed(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	conn, _ := newTestConn(t)
+	peer := addTestPeer(t, session, "alice", conn)
+	if err := peer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	newConn, _ := newTestConn(t)
+	if err := peer.resume(newConn, peer.ResumeToken(), 0, nil); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("resume = %v, want ErrPeerNotFound", err)
+	}
+}
+
+func TestPeerNotificationOrder(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	conn, client := newTestConn(t)
+	peer := addTestPeer(t, session, "alice", conn)
+
+	const senders, perSender = 8, 20
+	var wg sync.WaitGroup
+	for i := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range perSender {
+				if err := peer.SendNotification("test", map[string]any{"n": fmt.Sprint(i, j)}); err != nil {
+					t.Errorf("SendNotification: %v", err)
+				}
+			}
+		}()
+	}
+
+	for want := uint64(1); want <= senders*perSender; want++ {
+		if msg := client.read(); msg.Seq != want {
+			t.Fatalf("seq = %d, want %d", msg.Seq, want)
+		}
+	}
+	wg.Wait()
+}
+
+func TestPeerStalledClientDoesNotBlockPeer(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	conn, _ := newTestConn(t)
+	peer := addTestPeer(t, session, "alice", conn)
+
+	// The client never reads, so the writes eventually block
+	payload := map[string]any{"data": string(make([]byte, 64*1024))}
+	go func() {
+		for range maxNotificationHistory {
+			if peer.SendNotification("test", payload) != nil {
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		done := make(chan struct{})
+		go func() {
+			peer.connected()
+			peer.isClosed()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("peer state blocked by a stalled write")
+		}
+	}
+}
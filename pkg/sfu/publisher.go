@@ -3,31 +3,34 @@ package sfu
 import (
 	"log/slog"
 	"sync"
+	"time"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v4"
 )
 
+const (
+	rembInterval = time.Second
+)
+
 // Publisher handles the publishing (upstream) connection from a client.
 type Publisher struct {
-	peer   *Peer
-	pc     *webrtc.PeerConnection
-	router *Router
-	tracks map[string]*TrackReceiver
-	mu     sync.RWMutex
-	closed bool
+	peer    *Peer
+	pc      *peerConnection
+	router  *Router
+	tracks  map[string]*TrackReceiver
+	mu      sync.RWMutex
+	closed  bool
+	closeCh chan struct{}
 }
 
-func newPublisher(peer *Peer) (*Publisher, error) {
-	pc, err := peer.session.sfu.NewPeerConnection()
-	if err != nil {
-		return nil, err
-	}
-
+func newPublisher(peer *Peer, pc *peerConnection) (*Publisher, error) {
 	p := &Publisher{
-		peer:   peer,
-		pc:     pc,
-		router: NewRouter(peer.id, peer.session),
-		tracks: make(map[string]*TrackReceiver),
+		peer:    peer,
+		pc:      pc,
+		router:  NewRouter(peer.id, peer.session),
+		tracks:  make(map[string]*TrackReceiver),
+		closeCh: make(chan struct{}),
 	}
 
 	pc.OnTrack(p.onTrack)
@@ -38,13 +41,26 @@ func newPublisher(peer *Peer) (*Publisher, error) {
 		}
 	})
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
-			if err := p.Close(); err != nil {
-				slog.Warn("publisher close error", slog.String("error", err.Error()))
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			pc.connected.Store(true)
+		case webrtc.PeerConnectionStateDisconnected:
+			// The client restarts ICE on its own connection; a shared one is
+			// restarted by the server, which carries the subscriber
+			if subscriber := peer.getSubscriber(); pc.shared && subscriber != nil {
+				if err := subscriber.RestartICE(); err != nil {
+					slog.Warn("ICE restart failed", slog.String("error", err.Error()))
+				}
 			}
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			peer.session.removeFailedPeer(peer, peerLeftFailed, "publisher", state)
 		}
 	})
 
+	if maxBandwidth := peer.session.sfu.config.Router.MaxBandwidth; maxBandwidth > 0 {
+		go p.rembLoop(maxBandwidth * 1000)
+	}
+
 	return p, nil
 }
 
@@ -63,6 +79,11 @@ func (p *Publisher) onDataChannel(dc *webrtc.DataChannel) {
 	})
 
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if !p.peer.grants.CanPublishData {
+			slog.Debug("[Publisher] Data channel message dropped, not allowed to publish data", slog.String("peerID", p.peer.id))
+			return
+		}
+
 		slog.Debug("[Publisher] Data channel message received",
 			slog.String("peerID", p.peer.id),
 			slog.Int("size", len(msg.Data)),
@@ -80,6 +101,11 @@ func (p *Publisher) onDataChannel(dc *webrtc.DataChannel) {
 
 // onTrack handles incoming tracks from the client.
 func (p *Publisher) onTrack(remoteTrack *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver) {
+	if !p.peer.grants.CanPublish {
+		slog.Warn("[Publisher] Track rejected, not allowed to publish", slog.String("peerID", p.peer.id), slog.String("trackID", remoteTrack.ID()))
+		return
+	}
+
 	p.mu.Lock()
 	if p.closed {
 		p.mu.Unlock()
@@ -108,17 +134,22 @@ func (p *Publisher) onTrack(remoteTrack *webrtc.TrackRemote, rtpReceiver *webrtc
 
 	// Create layer receiver
 	receiver := NewLayerReceiver(remoteTrack, rtpReceiver, layerName)
-	receiver.SetPeerConnection(p.pc)
+	receiver.SetPeerConnection(p.pc.PeerConnection)
+	if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
+		receiver.EnablePacketCache(p.peer.session.sfu.config.Router.MaxPacketTrack)
+	}
 	track.AddLayer(layerName, receiver)
 
-	// Register track with router (only for new tracks)
+	// Register track with router (only for new tracks). The router is registered
+	// first so that auto-subscribing peers get the track from AddTrack.
 	if isNewTrack {
-		p.router.AddTrack(track)
 		p.peer.session.AddRouter(p.peer.id, p.router)
+		p.router.AddTrack(track)
 	}
 
-	// Start reading RTP
+	// Start reading RTP and RTCP
 	go p.readRTP(receiver, track, layerName)
+	go receiver.readRTCP()
 }
 
 // readRTP reads RTP packets from a layer and forwards them.
@@ -139,27 +170,166 @@ func (p *Publisher) readRTP(receiver *LayerReceiver, track *TrackReceiver, layer
 	}
 }
 
+// rembLoop periodically caps the publisher's video bitrate with REMB.
+func (p *Publisher) rembLoop(bitrate uint64) {
+	ticker := time.NewTicker(rembInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-ticker.C:
+			p.sendREMB(bitrate)
+		}
+	}
+}
+
+// sendREMB sends a REMB covering all video layers of the publisher.
+func (p *Publisher) sendREMB(bitrate uint64) {
+	p.mu.RLock()
+	ssrcs := make([]uint32, 0, len(p.tracks))
+	for _, track := range p.tracks {
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		for _, layer := range track.GetLayers() {
+			ssrcs = append(ssrcs, uint32(layer.SSRC()))
+		}
+	}
+	p.mu.RUnlock()
+
+	if len(ssrcs) == 0 {
+		return
+	}
+
+	remb := &rtcp.ReceiverEstimatedMaximumBitrate{
+		Bitrate: float32(bitrate),
+		SSRCs:   ssrcs,
+	}
+	if err := p.pc.WriteRTCP([]rtcp.Packet{remb}); err != nil {
+		slog.Debug("[Publisher] Failed to send REMB", slog.String("peerID", p.peer.id), slog.String("error", err.Error()))
+	}
+}
+
 // HandleOffer processes an SDP offer and returns an answer.
+// Offers are handled one at a time, and an offer that cannot be answered is
+// rolled back to leave the connection stable for the next one.
+//
+// On a connection shared with the subscriber the server may have an offer of
+// its own pending. The server keeps it and rejects the client's offer with
+// ErrOfferCollision; the client rolls its offer back, answers the server and
+// offers again.
 func (p *Publisher) HandleOffer(offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
-	if err := p.pc.SetRemoteDescription(offer); err != nil {
+	if offer.Type != webrtc.SDPTypeOffer {
+		return nil, ErrInvalidOffer
+	}
+
+	p.pc.signalingMu.Lock()
+	defer p.pc.signalingMu.Unlock()
+
+	if p.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		slog.Info("[Publisher] Offer collides with a pending offer", slog.String("peerID", p.peer.id))
+		return nil, ErrOfferCollision
+	}
+
+	p.startConnectTimer()
+
+	remote := p.pc.RemoteDescription()
+	restartsICE := remote != nil && iceUfrag(remote.SDP) != iceUfrag(offer.SDP)
+
+	if err := p.pc.setRemoteDescription(offer); err != nil {
 		return nil, err
 	}
+	if restartsICE {
+		// The tracks and the router are kept; only the transport is replaced,
+		// and it has to connect within the connect timeout again
+		slog.Info("[Publisher] Client restarts ICE", slog.String("peerID", p.peer.id))
+		p.pc.resetConnectTimer()
+		p.startConnectTimer()
+	}
+	p.removeEndedTracks()
 
 	answer, err := p.pc.CreateAnswer(nil)
 	if err != nil {
+		p.rollback()
 		return nil, err
 	}
 
 	if err := p.pc.SetLocalDescription(answer); err != nil {
+		p.rollback()
 		return nil, err
 	}
 
 	return &answer, nil
 }
 
+// startConnectTimer removes the peer if the connection does not connect within
+// webrtc.timeouts.publisherconnect. p.pc.signalingMu must be held.
+func (p *Publisher) startConnectTimer() {
+	timeout := time.Duration(p.peer.session.sfu.config.WebRTC.Timeouts.PublisherConnect) * time.Second
+	p.pc.startConnectTimer(timeout, func() {
+		slog.Info("[Publisher] Connection did not connect in time", slog.String("peerID", p.peer.id))
+		p.peer.session.removeFailedPeer(p.peer, peerLeftTimeout, "publisher", p.pc.ConnectionState())
+	})
+}
+
+// rollback discards a remote offer that could not be answered.
+func (p *Publisher) rollback() {
+	if err := p.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+		slog.Warn("[Publisher] Rollback failed", slog.String("peerID", p.peer.id), slog.String("error", err.Error()))
+	}
+}
+
+// removeEndedTracks tears down the tracks the client stopped sending in the last offer.
+// Their receivers are stopped, or replaced, by SetRemoteDescription.
+func (p *Publisher) removeEndedTracks() {
+	receiving := make(map[*webrtc.RTPReceiver]struct{})
+	for _, transceiver := range p.pc.GetTransceivers() {
+		if transceiver.Direction() == webrtc.RTPTransceiverDirectionInactive {
+			continue
+		}
+		if receiver := transceiver.Receiver(); receiver != nil {
+			receiving[receiver] = struct{}{}
+		}
+	}
+
+	p.mu.Lock()
+	var ended []*TrackReceiver
+	for trackID, track := range p.tracks {
+		layers := track.GetLayers()
+		if len(layers) == 0 {
+			// onTrack has not added the first layer yet
+			continue
+		}
+
+		active := false
+		for _, layer := range layers {
+			if _, ok := receiving[layer.Receiver().RTPReceiver()]; ok {
+				active = true
+				break
+			}
+		}
+		if !active {
+			delete(p.tracks, trackID)
+			ended = append(ended, track)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, track := range ended {
+		slog.Info("[Publisher] Track removed", slog.String("peerID", p.peer.id), slog.String("trackID", track.TrackID()))
+		if err := track.Close(); err != nil {
+			slog.Warn("track close error", slog.String("error", err.Error()))
+		}
+		p.router.RemoveTrack(track.TrackID())
+	}
+}
+
 // AddICECandidate adds an ICE candidate to the publisher connection.
+// Candidates received before the offer are added once it is applied.
 func (p *Publisher) AddICECandidate(candidate webrtc.ICECandidateInit) error {
-	return p.pc.AddICECandidate(candidate)
+	return p.pc.addICECandidate(candidate)
 }
 
 // Router returns the router for this publisher.
@@ -175,6 +345,7 @@ func (p *Publisher) Close() error {
 		return nil
 	}
 	p.closed = true
+	close(p.closeCh)
 
 	for _, track := range p.tracks {
 		if err := track.Close(); err != nil {
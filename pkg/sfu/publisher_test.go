@@ -0,0 +1,114 @@
+package sfu
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// publishOffer creates an offer with all candidates from a client connection.
+func publishOffer(t *testing.T, client *webrtc.PeerConnection, options *webrtc.OfferOptions) webrtc.SessionDescription {
+	t.Helper()
+
+	gathered := webrtc.GatheringCompletePromise(client)
+	offer, err := client.CreateOffer(options)
+	if err != nil {
+		t.Fatalf("CreateOffer: %v", err)
+	}
+	if err := client.SetLocalDescription(offer); err != nil {
+		t.Fatalf("SetLocalDescription: %v", err)
+	}
+	<-gathered
+	return *client.LocalDescription()
+}
+
+// addServerCandidates adds the candidates the server gathered to a client connection.
+func addServerCandidates(t *testing.T, client *webrtc.PeerConnection, server *peerConnection) {
+	t.Helper()
+
+	waitFor(t, "the server to gather candidates", func() bool {
+		return server.ICEGatheringState() == webrtc.ICEGatheringStateComplete
+	})
+	mid := "0"
+	for line := range strings.Lines(server.LocalDescription().SDP) {
+		if candidate, ok := strings.CutPrefix(strings.TrimSpace(line), "a=candidate:"); ok {
+			if err := client.AddICECandidate(webrtc.ICECandidateInit{Candidate: "candidate:" + candidate, SDPMid: &mid}); err != nil {
+				t.Fatalf("AddICECandidate: %v", err)
+			}
+		}
+	}
+}
+
+func TestPublisherICERestartConnectTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// complete applies the answer to the restart offer
+		complete    bool
+		wantRemoved bool
+	}{
+		{"restart connects", true, false},
+		{"restart does not connect", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, func(c *Config) { c.WebRTC.Timeouts.PublisherConnect = 3 })
+			session := s.GetOrCreateSession("room")
+			conn, ws := newTestConn(t)
+			peer, err := session.AddPeer("alice", conn, allGrants(), RolePublisher, ModeDual)
+			if err != nil {
+				t.Fatalf("AddPeer: %v", err)
+			}
+			publisher := peer.getPublisher()
+
+			client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+			if err != nil {
+				t.Fatalf("NewPeerConnection: %v", err)
+			}
+			t.Cleanup(func() { _ = client.Close() })
+			if _, err := client.CreateDataChannel("data", nil); err != nil {
+				t.Fatalf("CreateDataChannel: %v", err)
+			}
+
+			answer, err := peer.HandleOffer(publishOffer(t, client, nil))
+			if err != nil {
+				t.Fatalf("HandleOffer: %v", err)
+			}
+			if err := client.SetRemoteDescription(*answer); err != nil {
+				t.Fatalf("SetRemoteDescription: %v", err)
+			}
+			addServerCandidates(t, client, publisher.pc)
+			waitFor(t, "the publisher to connect", publisher.pc.connected.Load)
+
+			answer, err = peer.HandleOffer(publishOffer(t, client, &webrtc.OfferOptions{ICERestart: true}))
+			if err != nil {
+				t.Fatalf("HandleOffer(restart): %v", err)
+			}
+			if publisher.pc.connected.Load() {
+				t.Fatal("connection still counted as connected after the ICE restart")
+			}
+			if tt.complete {
+				if err := client.SetRemoteDescription(*answer); err != nil {
+					t.Fatalf("SetRemoteDescription(restart): %v", err)
+				}
+				addServerCandidates(t, client, publisher.pc)
+			}
+
+			if !tt.wantRemoved {
+				waitFor(t, "the publisher to connect again", publisher.pc.connected.Load)
+				time.Sleep(3500 * time.Millisecond)
+				if peer.isClosed() {
+					t.Fatal("peer removed after the ICE restart connected")
+				}
+				return
+			}
+
+			msg := ws.readMethod("removed")
+			if msg.Params["reason"] != peerLeftTimeout || msg.Params["target"] != "publisher" {
+				t.Fatalf("removed = %+v", msg.Params)
+			}
+		})
+	}
+}
@@ -8,11 +8,15 @@ import (
 
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 )
 
 const (
 	rtpReadTimeout = 30 * time.Second
+	// keyframeRequestInterval is the minimum interval between the keyframe
+	// requests of subscribers forwarded for a layer
+	keyframeRequestInterval = 500 * time.Millisecond
 )
 
 // LayerReceiver receives RTP packets from a single quality layer.
@@ -25,17 +29,33 @@ type LayerReceiver struct {
 	closeCh     chan struct{}
 	mu          sync.RWMutex
 	closed      bool
+	// lastKeyframeRequest is when a keyframe request of a subscriber was last forwarded
+	lastKeyframeRequest time.Time
+	// cache keeps recent packets for retransmission, nil if disabled
+	cache *packetCache
+
+	// transportCCExtID is the negotiated transport-wide-cc header extension ID, 0 if absent.
+	transportCCExtID uint8
 }
 
 // NewLayerReceiver creates a new layer receiver.
 func NewLayerReceiver(track *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver, layerName string) *LayerReceiver {
-	return &LayerReceiver{
+	r := &LayerReceiver{
 		track:       track,
 		rtpReceiver: rtpReceiver,
 		codec:       track.Codec(),
 		layerName:   layerName,
 		closeCh:     make(chan struct{}),
 	}
+
+	for _, ext := range rtpReceiver.GetParameters().HeaderExtensions {
+		if ext.URI == sdp.TransportCCURI {
+			r.transportCCExtID = uint8(ext.ID)
+			break
+		}
+	}
+
+	return r
 }
 
 // SetPeerConnection sets the peer connection for sending RTCP.
@@ -43,6 +63,27 @@ func (r *LayerReceiver) SetPeerConnection(pc *webrtc.PeerConnection) {
 	r.pc = pc
 }
 
+// EnablePacketCache keeps the last size packets read for retransmission.
+// It must be called before the first ReadRTP.
+func (r *LayerReceiver) EnablePacketCache(size int) {
+	if size > 0 {
+		r.cache = newPacketCache(size)
+	}
+}
+
+// GetPacket returns a cached packet by its sequence number, or nil if it is not cached.
+func (r *LayerReceiver) GetPacket(seq uint16) *rtp.Packet {
+	if r.cache == nil {
+		return nil
+	}
+	return r.cache.Get(seq)
+}
+
+// RTPReceiver returns the RTP receiver the layer is read from.
+func (r *LayerReceiver) RTPReceiver() *webrtc.RTPReceiver {
+	return r.rtpReceiver
+}
+
 // TrackID returns the track identifier.
 func (r *LayerReceiver) TrackID() string {
 	return r.track.ID()
@@ -92,6 +133,40 @@ func (r *LayerReceiver) SendPLI() {
 	slog.Info("[LayerReceiver] PLI sent", slog.Uint64("ssrc", uint64(ssrc)), slog.String("trackID", r.track.ID()), slog.String("layer", r.layerName))
 }
 
+// RequestKeyframe forwards a keyframe request (PLI or FIR) of a subscriber to
+// the publisher as a PLI. Since one keyframe serves every subscriber of the
+// layer, requests are forwarded at most once per keyframeRequestInterval.
+// It reports whether the request was forwarded.
+func (r *LayerReceiver) RequestKeyframe() bool {
+	r.mu.Lock()
+	if time.Since(r.lastKeyframeRequest) < keyframeRequestInterval {
+		r.mu.Unlock()
+		return false
+	}
+	r.lastKeyframeRequest = time.Now()
+	r.mu.Unlock()
+
+	r.SendPLI()
+	return true
+}
+
+// SendNACK asks the publisher to retransmit packets that are not cached.
+func (r *LayerReceiver) SendNACK(seqs []uint16) {
+	if r.pc == nil || r.track == nil || len(seqs) == 0 {
+		return
+	}
+
+	ssrc := uint32(r.track.SSRC())
+	nack := &rtcp.TransportLayerNack{
+		MediaSSRC: ssrc,
+		Nacks:     rtcp.NackPairsFromSequenceNumbers(seqs),
+	}
+
+	if err := r.pc.WriteRTCP([]rtcp.Packet{nack}); err != nil {
+		slog.Debug("[LayerReceiver] Failed to send NACK", "error", err, "ssrc", ssrc, "trackID", r.track.ID(), "layer", r.layerName)
+	}
+}
+
 // ReadRTP reads a single RTP packet.
 func (r *LayerReceiver) ReadRTP() (*rtp.Packet, error) {
 	select {
@@ -109,9 +184,34 @@ func (r *LayerReceiver) ReadRTP() (*rtp.Packet, error) {
 		return nil, err
 	}
 
+	// The transport-wide sequence numbers belong to the publisher's connection
+	// and must not reach the subscribers
+	if r.transportCCExtID != 0 {
+		packet.DelExtension(r.transportCCExtID)
+	}
+
+	if r.cache != nil {
+		r.cache.Add(packet)
+	}
 	return packet, nil
 }
 
+// readRTCP consumes the RTCP packets of the publisher for this layer, which lets
+// the interceptors see its sender reports.
+func (r *LayerReceiver) readRTCP() {
+	for {
+		var err error
+		if rid := r.track.RID(); rid != "" {
+			_, _, err = r.rtpReceiver.ReadSimulcastRTCP(rid)
+		} else {
+			_, _, err = r.rtpReceiver.ReadRTCP()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 // Close closes the receiver.
 func (r *LayerReceiver) Close() error {
 	r.mu.Lock()
@@ -72,12 +72,43 @@ func (r *Router) AddTrack(track *TrackReceiver) {
 	}
 
 	// Notify other peers
-	r.session.Broadcast(r.id, "trackAdded", map[string]any{
-		"peerId":   r.id,
-		"trackId":  trackID,
+	r.session.broadcastTrack(r.id, "trackAdded", track)
+}
+
+// RemoveTrack removes a track, closes its forwarder and downtracks and notifies other peers.
+func (r *Router) RemoveTrack(trackID string) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+
+	track, ok := r.tracks[trackID]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	forwarder := r.forwarders[trackID]
+	delete(r.tracks, trackID)
+	delete(r.forwarders, trackID)
+	r.mu.Unlock()
+
+	// Closing the downtracks removes them from the subscribers' connections
+	if forwarder != nil {
+		forwarder.Close()
+	}
+
+	r.session.broadcastTrack(r.id, "trackRemoved", track)
+}
+
+// trackParams returns the notification params describing a track published by a peer.
+func trackParams(peerID string, track *TrackReceiver) map[string]any {
+	return map[string]any{
+		"peerId":   peerID,
+		"trackId":  track.TrackID(),
 		"streamId": track.StreamID(),
 		"kind":     track.Kind().String(),
-	})
+	}
 }
 
 // GetTrack returns a track receiver by ID.
@@ -136,11 +167,64 @@ func (r *Router) Subscribe(subscriber *Subscriber) error {
 	return nil
 }
 
-// Unsubscribe removes a subscriber from this router.
+// SubscribeTrack connects a single track to a subscriber, without subscribing
+// it to the other or future tracks of the router.
+func (r *Router) SubscribeTrack(subscriber *Subscriber, trackID string) error {
+	r.mu.RLock()
+	track, ok := r.tracks[trackID]
+	forwarder := r.forwarders[trackID]
+	r.mu.RUnlock()
+
+	if !ok {
+		return ErrTrackNotFound
+	}
+
+	if err := subscriber.AddDownTrack(track); err != nil {
+		return err
+	}
+
+	if dt := subscriber.GetDownTrack(trackID); dt != nil && dt.TrackReceiver() == track {
+		forwarder.AddDownTrack(dt)
+	}
+	return nil
+}
+
+// Unsubscribe removes a subscriber from this router and disconnects all its tracks.
 func (r *Router) Unsubscribe(subscriber *Subscriber) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	delete(r.subscribers, subscriber)
+	trackIDs := make([]string, 0, len(r.tracks))
+	for trackID := range r.tracks {
+		trackIDs = append(trackIDs, trackID)
+	}
+	r.mu.Unlock()
+
+	for _, trackID := range trackIDs {
+		if err := r.UnsubscribeTrack(subscriber, trackID); err != nil {
+			slog.Warn("[Router] Error unsubscribing track", "error", err, "trackID", trackID)
+		}
+	}
+}
+
+// UnsubscribeTrack disconnects a single track from a subscriber and removes its
+// downtrack from the subscriber connection.
+func (r *Router) UnsubscribeTrack(subscriber *Subscriber, trackID string) error {
+	r.mu.RLock()
+	track, ok := r.tracks[trackID]
+	forwarder := r.forwarders[trackID]
+	r.mu.RUnlock()
+
+	if !ok {
+		return ErrTrackNotFound
+	}
+
+	dt := subscriber.GetDownTrack(trackID)
+	if dt == nil || dt.TrackReceiver() != track {
+		return nil
+	}
+
+	forwarder.RemoveDownTrack(dt)
+	return dt.Close()
 }
 
 // Close closes the router and all its tracks.
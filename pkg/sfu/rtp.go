@@ -8,22 +8,47 @@ import (
 type rtpSequencer struct {
 	lastSeq   uint16
 	seqOffset uint16
-	lastTS    uint32
-	tsOffset  uint32
-	lastSSRC  uint32
-	inited    bool
+	// lastExt is lastSeq extended across wraparound
+	lastExt  uint64
+	lastTS   uint32
+	tsOffset uint32
+	lastSSRC uint32
+	inited   bool
+	// firstSeq is the first rewritten sequence number since the last SSRC change
+	firstSeq uint16
+	// history maps the most recent rewritten sequence numbers back to the
+	// packets they were rewritten from, indexed like packetCache
+	history []sentPacket
 }
 
-func newRTPSequencer() *rtpSequencer {
-	return &rtpSequencer{}
+// sentPacket maps a rewritten sequence number to the source packet.
+type sentPacket struct {
+	seq        uint16
+	sourceSSRC uint32
+	sourceSeq  uint16
+	// tsOffset is added to the source timestamp
+	tsOffset uint32
+	valid    bool
+}
+
+// newRTPSequencer creates a sequencer that remembers the last historySize
+// rewritten packets. Zero keeps no history.
+func newRTPSequencer(historySize int) *rtpSequencer {
+	s := &rtpSequencer{}
+	if historySize > 0 {
+		s.history = make([]sentPacket, historySize)
+	}
+	return s
 }
 
 // Rewrite adjusts the packet's sequence number, timestamp, and SSRC.
 func (s *rtpSequencer) Rewrite(packet *rtp.Packet, ssrc uint32) *rtp.Packet {
 	if !s.inited {
 		s.lastSeq = packet.SequenceNumber
+		s.lastExt = initialExtendedSeq(packet.SequenceNumber)
 		s.lastTS = packet.Timestamp
 		s.lastSSRC = ssrc
+		s.firstSeq = packet.SequenceNumber
 		s.inited = true
 	}
 
@@ -32,6 +57,7 @@ func (s *rtpSequencer) Rewrite(packet *rtp.Packet, ssrc uint32) *rtp.Packet {
 		s.seqOffset = s.lastSeq - packet.SequenceNumber + 1
 		s.tsOffset = s.lastTS - packet.Timestamp + 1
 		s.lastSSRC = packet.SSRC
+		s.firstSeq = s.lastSeq + 1
 	}
 
 	newPacket := packet.Clone()
@@ -40,11 +66,48 @@ func (s *rtpSequencer) Rewrite(packet *rtp.Packet, ssrc uint32) *rtp.Packet {
 	newPacket.SSRC = ssrc
 
 	s.lastSeq = newPacket.SequenceNumber
+	s.lastExt = unwrapSeq(s.lastExt, newPacket.SequenceNumber)
 	s.lastTS = newPacket.Timestamp
 
+	if s.history != nil {
+		s.history[s.lastExt%uint64(len(s.history))] = sentPacket{
+			seq:        newPacket.SequenceNumber,
+			sourceSSRC: packet.SSRC,
+			sourceSeq:  packet.SequenceNumber,
+			tsOffset:   s.tsOffset,
+			valid:      true,
+		}
+	}
+
 	return newPacket
 }
 
+// Lookup maps a rewritten sequence number back to its source packet, if it is
+// still in the history. A sequence number that was never sent, because the
+// source packet did not arrive, is mapped with the current offsets as long as
+// the source has not changed since.
+func (s *rtpSequencer) Lookup(seq uint16) (sentPacket, bool) {
+	if s.history == nil || !s.inited {
+		return sentPacket{}, false
+	}
+
+	if sent := s.history[unwrapSeq(s.lastExt, seq)%uint64(len(s.history))]; sent.valid && sent.seq == seq {
+		return sent, true
+	}
+
+	age := s.lastSeq - seq
+	if int(age) >= len(s.history) || age > s.lastSeq-s.firstSeq {
+		return sentPacket{}, false
+	}
+	return sentPacket{
+		seq:        seq,
+		sourceSSRC: s.lastSSRC,
+		sourceSeq:  seq - s.seqOffset,
+		tsOffset:   s.tsOffset,
+		valid:      true,
+	}, true
+}
+
 // IsKeyframe checks if an RTP packet contains a keyframe.
 func IsKeyframe(payload []byte, codecType string) bool {
 	if len(payload) == 0 {
@@ -0,0 +1,259 @@
+package sfu
+
+import (
+	"testing"
+)
+
+const testDownTrackSSRC = 99
+
+type sourcePacket struct {
+	ssrc uint32
+	seq  uint16
+}
+
+// sourceRange returns n consecutive packets of a layer from first.
+func sourceRange(ssrc uint32, first uint16, n int) []sourcePacket {
+	packets := make([]sourcePacket, n)
+	for i, seq := range seqRange(first, n) {
+		packets[i] = sourcePacket{ssrc: ssrc, seq: seq}
+	}
+	return packets
+}
+
+func concat(ranges ...[]sourcePacket) []sourcePacket {
+	var packets []sourcePacket
+	for _, r := range ranges {
+		packets = append(packets, r...)
+	}
+	return packets
+}
+
+func TestRTPSequencerRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets []sourcePacket
+		wantSeq []uint16
+	}{
+		{
+			name:    "single layer",
+			packets: sourceRange(1, 100, 3),
+			wantSeq: []uint16{101, 102, 103},
+		},
+		{
+			name:    "lost packet keeps the gap",
+			packets: []sourcePacket{{1, 100}, {1, 102}},
+			wantSeq: []uint16{101, 103},
+		},
+		{
+			name:    "layer switch continues the sequence",
+			packets: concat(sourceRange(1, 100, 2), sourceRange(2, 5000, 2)),
+			wantSeq: []uint16{101, 102, 103, 104},
+		},
+		{
+			name:    "source wraparound",
+			packets: sourceRange(1, 65533, 4),
+			wantSeq: []uint16{65534, 65535, 0, 1},
+		},
+		{
+			name:    "layer switch across wraparound",
+			packets: concat(sourceRange(1, 65534, 2), sourceRange(2, 300, 2)),
+			wantSeq: []uint16{65535, 0, 1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRTPSequencer(8)
+			for i, p := range tt.packets {
+				out := s.Rewrite(testPacket(p.ssrc, p.seq, uint32(p.seq)*3000), testDownTrackSSRC)
+				if out.SequenceNumber != tt.wantSeq[i] {
+					t.Fatalf("packet %d: seq = %d, want %d", i, out.SequenceNumber, tt.wantSeq[i])
+				}
+				if out.SSRC != testDownTrackSSRC {
+					t.Fatalf("packet %d: ssrc = %d, want %d", i, out.SSRC, testDownTrackSSRC)
+				}
+			}
+		})
+	}
+}
+
+func TestRTPSequencerRewriteTimestamp(t *testing.T) {
+	s := newRTPSequencer(0)
+	first := s.Rewrite(testPacket(1, 100, 90000), testDownTrackSSRC)
+	last := s.Rewrite(testPacket(1, 101, 93000), testDownTrackSSRC)
+	if last.Timestamp-first.Timestamp != 3000 {
+		t.Fatalf("timestamp delta = %d, want 3000", last.Timestamp-first.Timestamp)
+	}
+
+	// The new layer continues right after the last timestamp
+	switched := s.Rewrite(testPacket(2, 7, 5000), testDownTrackSSRC)
+	if switched.Timestamp != last.Timestamp+1 {
+		t.Fatalf("timestamp after switch = %d, want %d", switched.Timestamp, last.Timestamp+1)
+	}
+}
+
+func TestRTPSequencerLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		history int
+		packets []sourcePacket
+		seq     uint16
+		want    bool
+		source  sourcePacket
+	}{
+		{
+			name:    "sent",
+			history: 8,
+			packets: sourceRange(1, 10, 3),
+			seq:     12,
+			want:    true,
+			source:  sourcePacket{1, 11},
+		},
+		{
+			name:    "no history",
+			history: 0,
+			packets: sourceRange(1, 10, 3),
+			seq:     12,
+		},
+		{
+			name:    "nothing sent",
+			history: 8,
+			seq:     12,
+		},
+		{
+			name:    "not sent yet",
+			history: 8,
+			packets: sourceRange(1, 10, 3),
+			seq:     14,
+		},
+		{
+			name:    "lost packet falls back to the offset",
+			history: 8,
+			packets: []sourcePacket{{1, 10}, {1, 12}},
+			seq:     12,
+			want:    true,
+			source:  sourcePacket{1, 11},
+		},
+		{
+			name:    "lost before the first packet",
+			history: 8,
+			packets: sourceRange(1, 10, 2),
+			seq:     10,
+		},
+		{
+			name:    "sent before the layer switch",
+			history: 8,
+			packets: concat(sourceRange(1, 10, 2), sourceRange(2, 500, 2)),
+			seq:     12,
+			want:    true,
+			source:  sourcePacket{1, 11},
+		},
+		{
+			name:    "lost after the layer switch",
+			history: 8,
+			packets: concat(sourceRange(1, 10, 2), []sourcePacket{{2, 500}, {2, 502}}),
+			seq:     14,
+			want:    true,
+			source:  sourcePacket{2, 501},
+		},
+		{
+			name:    "evicted",
+			history: 4,
+			packets: sourceRange(1, 10, 8),
+			seq:     14,
+		},
+		{
+			name:    "oldest kept",
+			history: 4,
+			packets: sourceRange(1, 10, 8),
+			seq:     15,
+			want:    true,
+			source:  sourcePacket{1, 14},
+		},
+		{
+			name:    "sent before wraparound",
+			history: 500,
+			packets: sourceRange(1, 65400, 300),
+			seq:     65450,
+			want:    true,
+			source:  sourcePacket{1, 65449},
+		},
+		{
+			name:    "sent after wraparound",
+			history: 500,
+			packets: sourceRange(1, 65400, 300),
+			seq:     100,
+			want:    true,
+			source:  sourcePacket{1, 99},
+		},
+		{
+			name:    "lost at wraparound",
+			history: 500,
+			packets: concat(sourceRange(1, 65530, 5), sourceRange(1, 0, 5)),
+			seq:     0,
+			want:    true,
+			source:  sourcePacket{1, 65535},
+		},
+		{
+			name:    "evicted across wraparound",
+			history: 500,
+			packets: sourceRange(1, 65000, 1000),
+			seq:     65500,
+		},
+		{
+			name:    "oldest kept across wraparound",
+			history: 500,
+			packets: sourceRange(1, 65000, 1000),
+			seq:     65501,
+			want:    true,
+			source:  sourcePacket{1, 65500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRTPSequencer(tt.history)
+			for _, p := range tt.packets {
+				s.Rewrite(testPacket(p.ssrc, p.seq, 0), testDownTrackSSRC)
+			}
+
+			sent, ok := s.Lookup(tt.seq)
+			if ok != tt.want {
+				t.Fatalf("Lookup(%d) ok = %v, want %v (%+v)", tt.seq, ok, tt.want, sent)
+			}
+			if !ok {
+				return
+			}
+			if sent.seq != tt.seq || sent.sourceSSRC != tt.source.ssrc || sent.sourceSeq != tt.source.seq {
+				t.Fatalf("Lookup(%d) = %+v, want source %+v", tt.seq, sent, tt.source)
+			}
+		})
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   string
+		payload []byte
+		want    bool
+	}{
+		{"VP8 keyframe", "video/VP8", []byte{0x10}, true},
+		{"VP8 interframe", "video/VP8", []byte{0x11}, false},
+		{"VP9 keyframe", "video/VP9", []byte{0x00}, true},
+		{"VP9 interframe", "video/VP9", []byte{0x40}, false},
+		{"H264 IDR", "video/H264", []byte{0x65}, true},
+		{"H264 SPS", "video/H264", []byte{0x67}, true},
+		{"H264 non-IDR", "video/H264", []byte{0x41}, false},
+		{"empty payload", "video/VP8", nil, false},
+		{"audio", "audio/opus", []byte{0x10}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsKeyframe(tt.payload, tt.codec); got != tt.want {
+				t.Errorf("IsKeyframe = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
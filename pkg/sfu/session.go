@@ -2,7 +2,22 @@ package sfu
 
 import (
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Reasons sent with peerLeft
+const (
+	peerLeftLeave        = "left"
+	peerLeftKicked       = "kicked"
+	peerLeftDisconnected = "disconnected"
+	peerLeftReplaced     = "replaced"
+	peerLeftFailed       = "connection_failed"
+	peerLeftTimeout      = "connect_timeout"
 )
 
 // Session represents a room where multiple peers can join and share media.
@@ -12,15 +27,28 @@ type Session struct {
 	peers   map[string]*Peer
 	routers map[string]*Router
 	mu      sync.RWMutex
+	closed  bool
+	// emptyTimer closes the session once it has been empty for session.emptytimeout
+	emptyTimer *time.Timer
+	// maxDurationTimer closes the session once it reaches session.maxduration
+	maxDurationTimer *time.Timer
 }
 
 func newSession(id string, sfu *SFU) *Session {
-	return &Session{
+	s := &Session{
 		id:      id,
 		sfu:     sfu,
 		peers:   make(map[string]*Peer),
 		routers: make(map[string]*Router),
 	}
+
+	if maxDuration := sfu.config.Session.MaxDuration; maxDuration > 0 {
+		s.maxDurationTimer = time.AfterFunc(time.Duration(maxDuration)*time.Second, func() {
+			sfu.closeSession(s, SessionCloseMaxDuration)
+		})
+	}
+
+	return s
 }
 
 // ID returns the session identifier.
@@ -28,17 +56,44 @@ func (s *Session) ID() string {
 	return s.id
 }
 
-// AddPeer creates and adds a new peer to the session.
-func (s *Session) AddPeer(peerID string, conn *wsConn) (*Peer, error) {
+// AddPeer creates and adds a new peer with the given grants, role and connection
+// mode to the session.
+// A peer with the same ID that lost its signaling connection is replaced;
+// a connected one is kept and ErrPeerExists is returned.
+func (s *Session) AddPeer(peerID string, conn *wsConn, grants Grants, role Role, mode Mode) (*Peer, error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
-	peer, err := newPeer(peerID, s, conn)
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+
+	var replacedTracks map[string]*TrackReceiver
+	replaced := false
+	if existing, ok := s.peers[peerID]; ok {
+		if existing.connected() {
+			s.mu.Unlock()
+			return nil, ErrPeerExists
+		}
+		replacedTracks, replaced = s.removePeerLocked(peerID)
+	}
+
+	peer, err := newPeer(peerID, s, conn, grants, role, mode)
 	if err != nil {
+		s.mu.Unlock()
 		return nil, err
 	}
 
 	s.peers[peerID] = peer
+	if s.emptyTimer != nil {
+		s.emptyTimer.Stop()
+	}
+	s.mu.Unlock()
+
+	if replaced {
+		slog.Info("disconnected peer replaced", slog.String("sessionID", s.id), slog.String("peerID", peerID))
+		s.notifyPeerLeft(peerID, replacedTracks, peerLeftReplaced)
+	}
 	return peer, nil
 }
 
@@ -54,31 +109,195 @@ func (s *Session) GetPeer(peerID string) (*Peer, error) {
 	return peer, nil
 }
 
-// RemovePeer removes a peer and its associated router from the session.
+// RemovePeer removes a peer and its associated router from the session
+// and tells the remaining peers that it left.
 func (s *Session) RemovePeer(peerID string) {
+	s.removePeer(peerID, peerLeftLeave)
+}
+
+func (s *Session) removePeer(peerID, reason string) {
+	s.mu.Lock()
+	tracks, removed := s.removePeerLocked(peerID)
+	s.mu.Unlock()
+
+	if removed {
+		s.notifyPeerLeft(peerID, tracks, reason)
+	}
+}
+
+// removeJoiningPeer removes a peer whose join failed. The room was not told
+// that the peer joined, so it is not told that the peer left either.
+func (s *Session) removeJoiningPeer(peer *Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.peers[peer.ID()]; ok && current == peer {
+		s.removePeerLocked(peer.ID())
+	}
+}
+
+// notifyPeerLeft tells the remaining peers that a peer and its tracks are gone.
+func (s *Session) notifyPeerLeft(peerID string, tracks map[string]*TrackReceiver, reason string) {
+	for _, track := range tracks {
+		s.Broadcast(peerID, "trackRemoved", trackParams(peerID, track))
+	}
+	s.Broadcast(peerID, "peerLeft", map[string]any{
+		"peerId": peerID,
+		"reason": reason,
+	})
+}
+
+// PeerDisconnected handles the loss of a peer's signaling connection.
+// The peer is removed once the disconnect grace period passes.
+func (s *Session) PeerDisconnected(peer *Peer, conn *wsConn) {
+	grace := time.Duration(s.sfu.config.Signal.DisconnectGrace) * time.Second
+	if !peer.detach(conn, grace, func() { s.removeDisconnectedPeer(peer) }) {
+		return
+	}
+	slog.Info("peer disconnected", slog.String("sessionID", s.id), slog.String("peerID", peer.ID()), slog.Duration("grace", grace))
+}
+
+// removeDisconnectedPeer removes a peer that did not reconnect and tells the room.
+func (s *Session) removeDisconnectedPeer(peer *Peer) {
+	s.mu.Lock()
+	if current, ok := s.peers[peer.ID()]; !ok || current != peer || peer.connected() {
+		s.mu.Unlock()
+		return
+	}
+	tracks, _ := s.removePeerLocked(peer.ID())
+	s.mu.Unlock()
+
+	slog.Info("disconnected peer removed", slog.String("sessionID", s.id), slog.String("peerID", peer.ID()))
+	s.notifyPeerLeft(peer.ID(), tracks, peerLeftDisconnected)
+}
+
+// removeFailedPeer removes a peer whose PeerConnection failed, was closed or did
+// not connect in time, and tells the room. The client is told why, and its
+// signaling connection is kept open so that it can join again.
+func (s *Session) removeFailedPeer(peer *Peer, reason, target string, state webrtc.PeerConnectionState) {
+	s.mu.Lock()
+	if current, ok := s.peers[peer.ID()]; !ok || current != peer {
+		s.mu.Unlock()
+		return
+	}
+	if err := peer.SendNotification("removed", map[string]any{
+		"reason": reason,
+		"target": target,
+		"state":  state.String(),
+	}); err != nil {
+		slog.Warn("failed to notify removed peer", slog.String("peerID", peer.ID()), slog.String("error", err.Error()))
+	}
+	peer.unbind()
+	tracks, _ := s.removePeerLocked(peer.ID())
+	s.mu.Unlock()
+
+	slog.Info("peer removed after connection failure", slog.String("sessionID", s.id), slog.String("peerID", peer.ID()), slog.String("reason", reason), slog.String("target", target), slog.String("state", state.String()))
+	s.notifyPeerLeft(peer.ID(), tracks, reason)
+}
+
+// scheduleEmptyClose starts the empty timeout if the session has no peers.
+func (s *Session) scheduleEmptyClose() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if peer, ok := s.peers[peerID]; ok {
+	if len(s.peers) == 0 {
+		s.scheduleEmptyCloseLocked()
+	}
+}
+
+// scheduleEmptyCloseLocked starts the empty timeout. s.mu must be held.
+func (s *Session) scheduleEmptyCloseLocked() {
+	if s.closed {
+		return
+	}
+
+	timeout := time.Duration(s.sfu.config.Session.EmptyTimeout) * time.Second
+	if s.emptyTimer == nil {
+		s.emptyTimer = time.AfterFunc(timeout, func() {
+			s.sfu.closeSession(s, SessionCloseEmpty)
+		})
+		return
+	}
+	s.emptyTimer.Reset(timeout)
+}
+
+// removePeerLocked removes a peer and its router. s.mu must be held.
+// It returns the tracks the peer published and whether the peer was found.
+func (s *Session) removePeerLocked(peerID string) (map[string]*TrackReceiver, bool) {
+	peer, removed := s.peers[peerID]
+	if removed {
 		if err := peer.Close(); err != nil {
 			slog.Warn("peer close error", slog.String("peerID", peerID), slog.String("error", err.Error()))
 		}
 		delete(s.peers, peerID)
 	}
 
+	var tracks map[string]*TrackReceiver
 	if router, ok := s.routers[peerID]; ok {
+		tracks = router.GetTracks()
 		if err := router.Close(); err != nil {
 			slog.Warn("router close error", slog.String("peerID", peerID), slog.String("error", err.Error()))
 		}
 		delete(s.routers, peerID)
 	}
+
+	if len(s.peers) == 0 {
+		s.scheduleEmptyCloseLocked()
+	}
+	return tracks, removed
 }
 
-// AddRouter registers a router for a peer.
+// AddRouter registers a router for a peer and subscribes the auto-subscribing peers to it.
 func (s *Session) AddRouter(peerID string, router *Router) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	if existing, ok := s.routers[peerID]; ok && existing == router {
+		s.mu.Unlock()
+		return
+	}
 	s.routers[peerID] = router
+
+	var subscribers []*Peer
+	for _, peer := range s.peers {
+		if s.autoSubscribes(peer, peerID) {
+			subscribers = append(subscribers, peer)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, peer := range subscribers {
+		if err := peer.Subscribe(router); err != nil {
+			slog.Warn("auto subscribe error", slog.String("peerID", peer.ID()), slog.String("routerID", peerID), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// autoSubscribes reports whether a peer is subscribed automatically to the router of publisherID.
+func (s *Session) autoSubscribes(peer *Peer, publisherID string) bool {
+	if !peer.autoSubscribe.Load() || !peer.grants.CanSubscribe {
+		return false
+	}
+	return peer.ID() != publisherID || s.sfu.config.Router.SelfSubscribe
+}
+
+// AutoSubscribe subscribes a peer to all current and future routers of the session.
+// The peer's own router is included if selfsubscribe is set.
+func (s *Session) AutoSubscribe(peer *Peer) {
+	peer.autoSubscribe.Store(true)
+
+	s.mu.RLock()
+	routers := make([]*Router, 0, len(s.routers))
+	for peerID, router := range s.routers {
+		if s.autoSubscribes(peer, peerID) {
+			routers = append(routers, router)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, router := range routers {
+		if err := peer.Subscribe(router); err != nil {
+			slog.Warn("auto subscribe error", slog.String("peerID", peer.ID()), slog.String("routerID", router.ID()), slog.String("error", err.Error()))
+		}
+	}
 }
 
 // GetRouter returns the router for a peer.
@@ -90,57 +309,141 @@ func (s *Session) GetRouter(peerID string) (*Router, bool) {
 }
 
 // Subscribe connects a subscriber to a publisher's router.
-func (s *Session) Subscribe(subscriberID, publisherID string) error {
+// With trackIDs only those tracks are subscribed, otherwise all current and future tracks.
+func (s *Session) Subscribe(subscriberID, publisherID string, trackIDs ...string) error {
+	if subscriberID == publisherID && !s.sfu.config.Router.SelfSubscribe {
+		return ErrSelfSubscribeDisabled
+	}
+
+	subscriber, router, err := s.subscription(subscriberID, publisherID)
+	if err != nil {
+		return err
+	}
+
+	if len(trackIDs) > 0 {
+		return subscriber.SubscribeTracks(router, trackIDs)
+	}
+	return subscriber.Subscribe(router)
+}
+
+// Unsubscribe disconnects a subscriber from a publisher's router.
+// With trackIDs only those tracks are unsubscribed, otherwise all of them.
+func (s *Session) Unsubscribe(subscriberID, publisherID string, trackIDs ...string) error {
+	subscriber, router, err := s.subscription(subscriberID, publisherID)
+	if err != nil {
+		return err
+	}
+
+	if len(trackIDs) > 0 {
+		return subscriber.UnsubscribeTracks(router, trackIDs)
+	}
+	subscriber.Unsubscribe(router)
+	return nil
+}
+
+// subscription looks up a subscribing peer and a publisher's router.
+func (s *Session) subscription(subscriberID, publisherID string) (*Peer, *Router, error) {
 	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	subscriber, ok := s.peers[subscriberID]
 	if !ok {
-		s.mu.RUnlock()
-		return ErrPeerNotFound
+		return nil, nil, ErrPeerNotFound
 	}
 
 	router, ok := s.routers[publisherID]
 	if !ok {
-		s.mu.RUnlock()
-		return ErrPeerNotFound
+		return nil, nil, ErrPeerNotFound
 	}
-	s.mu.RUnlock()
+	return subscriber, router, nil
+}
 
-	return subscriber.Subscribe(router)
+// broadcastTrack sends a track notification about a track of publisherID.
+// With selfsubscribe the publisher is notified too, so that it can subscribe to its own tracks.
+func (s *Session) broadcastTrack(publisherID, method string, track *TrackReceiver) {
+	exclude := publisherID
+	if s.sfu.config.Router.SelfSubscribe {
+		exclude = ""
+	}
+	s.Broadcast(exclude, method, trackParams(publisherID, track))
 }
 
 // NotifyExistingTracks sends trackAdded notifications for all existing tracks to a new peer.
 func (s *Session) NotifyExistingTracks(peer *Peer) {
+	type existingTrack struct {
+		publisherID string
+		track       *TrackReceiver
+	}
+
+	s.mu.RLock()
+	var tracks []existingTrack
+	for peerID, router := range s.routers {
+		if peerID == peer.ID() && !s.sfu.config.Router.SelfSubscribe {
+			continue
+		}
+
+		for _, track := range router.GetTracks() {
+			tracks = append(tracks, existingTrack{publisherID: peerID, track: track})
+		}
+	}
+	s.mu.RUnlock()
+
+	// Notifications are written outside the lock, so that a slow client does not block the session
+	for _, t := range tracks {
+		if err := peer.SendNotification("trackAdded", trackParams(t.publisherID, t.track)); err != nil {
+			slog.Warn("failed to notify existing track", slog.String("peerID", t.publisherID), slog.String("trackID", t.track.TrackID()), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// snapshot describes the peers of the session and their tracks, except the excluded peer.
+func (s *Session) snapshot(excludePeerID string) []peerInfo {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for peerID, router := range s.routers {
-		if peerID == peer.ID() {
+	peers := make([]peerInfo, 0, len(s.peers))
+	for peerID := range s.peers {
+		if peerID == excludePeerID {
 			continue
 		}
 
-		for trackID, track := range router.GetTracks() {
-			if err := peer.SendNotification("trackAdded", map[string]any{
-				"peerId":   peerID,
-				"trackId":  trackID,
-				"streamId": track.StreamID(),
-				"kind":     track.Kind().String(),
-			}); err != nil {
-				slog.Warn("failed to notify existing track", slog.String("peerID", peerID), slog.String("trackID", trackID), slog.String("error", err.Error()))
+		info := peerInfo{PeerID: peerID, Tracks: []trackInfo{}}
+		if router, ok := s.routers[peerID]; ok {
+			for trackID, track := range router.GetTracks() {
+				info.Tracks = append(info.Tracks, trackInfo{
+					TrackID:  trackID,
+					StreamID: track.StreamID(),
+					Kind:     track.Kind().String(),
+				})
 			}
+			slices.SortFunc(info.Tracks, func(a, b trackInfo) int {
+				return strings.Compare(a.TrackID, b.TrackID)
+			})
 		}
+		peers = append(peers, info)
 	}
+
+	slices.SortFunc(peers, func(a, b peerInfo) int {
+		return strings.Compare(a.PeerID, b.PeerID)
+	})
+	return peers
 }
 
 // Broadcast sends a message to all peers except the excluded one.
 func (s *Session) Broadcast(excludePeerID string, method string, params map[string]any) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
+	peers := make([]*Peer, 0, len(s.peers))
 	for peerID, peer := range s.peers {
 		if peerID != excludePeerID {
-			if err := peer.SendNotification(method, params); err != nil {
-				slog.Warn("broadcast notification error", slog.String("peerID", peerID), slog.String("method", method), slog.String("error", err.Error()))
-			}
+			peers = append(peers, peer)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Notifications are written outside the lock, so that a slow client does not block the session
+	for _, peer := range peers {
+		if err := peer.SendNotification(method, params); err != nil {
+			slog.Warn("broadcast notification error", slog.String("peerID", peer.ID()), slog.String("method", method), slog.String("error", err.Error()))
 		}
 	}
 }
@@ -162,11 +465,33 @@ func (s *Session) BroadcastData(senderPeerID string, data []byte) {
 	}
 }
 
+// markClosed marks the session closed so that no peers can be added.
+// With requireEmpty it fails if the session has peers.
+// It returns false if the session was not marked.
+func (s *Session) markClosed(requireEmpty bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || (requireEmpty && len(s.peers) > 0) {
+		return false
+	}
+	s.closed = true
+	return true
+}
+
 // Close closes the session and all its peers and routers.
 func (s *Session) Close() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.closed = true
+	if s.emptyTimer != nil {
+		s.emptyTimer.Stop()
+	}
+	if s.maxDurationTimer != nil {
+		s.maxDurationTimer.Stop()
+	}
+
 	for _, peer := range s.peers {
 		if err := peer.Close(); err != nil {
 			slog.Warn("peer close error", slog.String("peerID", peer.ID()), slog.String("error", err.Error()))
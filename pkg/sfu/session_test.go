@@ -0,0 +1,315 @@
+package sfu
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestAddPeerConnectedDuplicate(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+
+	conn, _ := newTestConn(t)
+	first := addTestPeer(t, session, "alice", conn)
+
+	other, _ := newTestConn(t)
+	if _, err := session.AddPeer("alice", other, allGrants(), RoleBoth, ModeDual); !errors.Is(err, ErrPeerExists) {
+		t.Fatalf("AddPeer = %v, want ErrPeerExists", err)
+	}
+
+	current, err := session.GetPeer("alice")
+	if err != nil || current != first {
+		t.Fatalf("GetPeer = %p, %v, want the first peer", current, err)
+	}
+	if first.isClosed() {
+		t.Fatal("the connected peer was closed")
+	}
+}
+
+func TestAddPeerReplacesDisconnected(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+
+	bobConn, bob := newTestConn(t)
+	addTestPeer(t, session, "bob", bobConn)
+
+	aliceConn, _ := newTestConn(t)
+	first := addTestPeer(t, session, "alice", aliceConn)
+	session.PeerDisconnected(first, aliceConn)
+
+	if _, err := session.GetPeer("alice"); err != nil {
+		t.Fatalf("disconnected peer removed before the grace period: %v", err)
+	}
+
+	newConn, _ := newTestConn(t)
+	second := addTestPeer(t, session, "alice", newConn)
+	if second == first {
+		t.Fatal("AddPeer returned the disconnected peer")
+	}
+	if !first.isClosed() {
+		t.Fatal("the replaced peer was not closed")
+	}
+
+	msg := bob.readMethod("peerLeft")
+	if msg.Params["peerId"] != "alice" || msg.Params["reason"] != peerLeftReplaced {
+		t.Fatalf("peerLeft = %+v", msg.Params)
+	}
+}
+
+func TestPeerDisconnectedGrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace int
+	}{
+		{"no grace", 0},
+		{"grace period", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, func(c *Config) { c.Signal.DisconnectGrace = tt.grace })
+			session := s.GetOrCreateSession("room")
+
+			bobConn, bob := newTestConn(t)
+			addTestPeer(t, session, "bob", bobConn)
+			aliceConn, _ := newTestConn(t)
+			alice := addTestPeer(t, session, "alice", aliceConn)
+
+			start := time.Now()
+			session.PeerDisconnected(alice, aliceConn)
+			if alice.connected() {
+				t.Fatal("peer still has its signaling connection")
+			}
+
+			msg := bob.readMethod("peerLeft")
+			if msg.Params["peerId"] != "alice" || msg.Params["reason"] != peerLeftDisconnected {
+				t.Fatalf("peerLeft = %+v", msg.Params)
+			}
+			if elapsed := time.Since(start); elapsed < time.Duration(tt.grace)*time.Second {
+				t.Fatalf("peer removed after %v, before the grace period", elapsed)
+			}
+			if _, err := session.GetPeer("alice"); !errors.Is(err, ErrPeerNotFound) {
+				t.Fatalf("GetPeer = %v, want ErrPeerNotFound", err)
+			}
+			if !alice.isClosed() {
+				t.Fatal("removed peer was not closed")
+			}
+		})
+	}
+}
+
+func TestPeerDisconnectedStaleConn(t *testing.T) {
+	s := newTestSFU(t, func(c *Config) { c.Signal.DisconnectGrace = 0 })
+	session := s.GetOrCreateSession("room")
+
+	conn, _ := newTestConn(t)
+	alice := addTestPeer(t, session, "alice", conn)
+
+	// A connection the peer is no longer bound to does not detach it
+	stale, _ := newTestConn(t)
+	session.PeerDisconnected(alice, stale)
+
+	time.Sleep(50 * time.Millisecond)
+	if !alice.connected() || alice.isClosed() {
+		t.Fatal("peer detached by a stale connection")
+	}
+	if _, err := session.GetPeer("alice"); err != nil {
+		t.Fatalf("GetPeer = %v", err)
+	}
+}
+
+func TestPeerDetach(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	conn, _ := newTestConn(t)
+	peer := addTestPeer(t, session, "alice", conn)
+
+	removed := make(chan struct{})
+	remove := func() { close(removed) }
+
+	other, _ := newTestConn(t)
+	if peer.detach(other, time.Millisecond, remove) {
+		t.Fatal("detach succeeded for another connection")
+	}
+	if !peer.detach(conn, 10*time.Millisecond, remove) {
+		t.Fatal("detach failed for the peer's connection")
+	}
+	if peer.detach(conn, time.Millisecond, remove) {
+		t.Fatal("detach succeeded twice")
+	}
+
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("remove was not called after the grace period")
+	}
+}
+
+// testTrack returns an audio track with a default layer that receives no packets.
+func testTrack(trackID string) *TrackReceiver {
+	track := NewTrackReceiver(trackID, "stream", webrtc.RTPCodecTypeAudio)
+	track.layers[LayerDefault] = &Layer{
+		name: LayerDefault,
+		receiver: &LayerReceiver{
+			codec: webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+				PayloadType:        111,
+			},
+			layerName: LayerDefault,
+			closeCh:   make(chan struct{}),
+		},
+		active: true,
+	}
+	return track
+}
+
+// downTrackIDs returns the IDs of the tracks a subscriber receives.
+func downTrackIDs(subscriber *Subscriber) []string {
+	subscriber.mu.RLock()
+	defer subscriber.mu.RUnlock()
+
+	ids := make([]string, 0, len(subscriber.downTracks))
+	for id := range subscriber.downTracks {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestUnsubscribeTracks(t *testing.T) {
+	tests := []struct {
+		name        string
+		unsubscribe []string
+		wantErr     error
+		want        []string
+	}{
+		{"all tracks", nil, nil, nil},
+		{"some tracks", []string{"a1", "a3"}, nil, []string{"a2"}},
+		{"one track", []string{"a2"}, nil, []string{"a1", "a3"}},
+		{"unknown track", []string{"a1", "missing"}, ErrTrackNotFound, []string{"a1", "a2", "a3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, nil)
+			session := s.GetOrCreateSession("room")
+			bobConn, _ := newTestConn(t)
+			addTestPeer(t, session, "bob", bobConn)
+			aliceConn, _ := newTestConn(t)
+			alice := addTestPeer(t, session, "alice", aliceConn)
+
+			router := NewRouter("bob", session)
+			for _, id := range []string{"a1", "a2", "a3"} {
+				router.AddTrack(testTrack(id))
+			}
+			session.AddRouter("bob", router)
+
+			if err := session.Subscribe("alice", "bob"); err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			if got := downTrackIDs(alice.getSubscriber()); !slices.Equal(got, []string{"a1", "a2", "a3"}) {
+				t.Fatalf("subscribed tracks = %v", got)
+			}
+
+			if err := session.Unsubscribe("alice", "bob", tt.unsubscribe...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unsubscribe = %v, want %v", err, tt.wantErr)
+			}
+			if got := downTrackIDs(alice.getSubscriber()); !slices.Equal(got, tt.want) {
+				t.Fatalf("tracks after unsubscribe = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsubscribeTracksKeepsRouterSubscription(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	bobConn, _ := newTestConn(t)
+	addTestPeer(t, session, "bob", bobConn)
+	aliceConn, _ := newTestConn(t)
+	alice := addTestPeer(t, session, "alice", aliceConn)
+
+	router := NewRouter("bob", session)
+	router.AddTrack(testTrack("a1"))
+	session.AddRouter("bob", router)
+
+	if err := session.Subscribe("alice", "bob"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := session.Unsubscribe("alice", "bob", "a1"); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+
+	// Tracks published later are still added
+	router.AddTrack(testTrack("a2"))
+	if got := downTrackIDs(alice.getSubscriber()); !slices.Equal(got, []string{"a2"}) {
+		t.Fatalf("tracks = %v, want [a2]", got)
+	}
+}
+
+func TestRemoveFailedPeer(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	bobConn, bob := newTestConn(t)
+	addTestPeer(t, session, "bob", bobConn)
+	aliceConn, alice := newTestConn(t)
+	peer := addTestPeer(t, session, "alice", aliceConn)
+
+	session.removeFailedPeer(peer, peerLeftFailed, "subscriber", webrtc.PeerConnectionStateFailed)
+
+	msg := alice.readMethod("removed")
+	want := map[string]any{"reason": peerLeftFailed, "target": "subscriber", "state": "failed"}
+	for key, value := range want {
+		if msg.Params[key] != value {
+			t.Fatalf("removed = %+v, want %+v", msg.Params, want)
+		}
+	}
+	msg = bob.readMethod("peerLeft")
+	if msg.Params["peerId"] != "alice" || msg.Params["reason"] != peerLeftFailed {
+		t.Fatalf("peerLeft = %+v", msg.Params)
+	}
+
+	if _, err := session.GetPeer("alice"); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("GetPeer = %v, want ErrPeerNotFound", err)
+	}
+	if !peer.isClosed() {
+		t.Fatal("removed peer was not closed")
+	}
+	// The signaling connection stays open so that the client can join again
+	if err := aliceConn.WriteMessage(websocket.TextMessage, []byte(`{}`)); err != nil {
+		t.Fatalf("signaling connection closed: %v", err)
+	}
+}
+
+func TestRemoveFailedPeerReplaced(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	bobConn, bob := newTestConn(t)
+	addTestPeer(t, session, "bob", bobConn)
+
+	aliceConn, _ := newTestConn(t)
+	old := addTestPeer(t, session, "alice", aliceConn)
+	session.PeerDisconnected(old, aliceConn)
+	newConn, alice := newTestConn(t)
+	current := addTestPeer(t, session, "alice", newConn)
+	if msg := bob.readMethod("peerLeft"); msg.Params["reason"] != peerLeftReplaced {
+		t.Fatalf("peerLeft = %+v", msg.Params)
+	}
+
+	// The old peer's connection fails after it was replaced
+	session.removeFailedPeer(old, peerLeftFailed, "publisher", webrtc.PeerConnectionStateClosed)
+
+	if got, err := session.GetPeer("alice"); err != nil || got != current {
+		t.Fatalf("GetPeer = %p, %v, want the new peer", got, err)
+	}
+	if current.isClosed() {
+		t.Fatal("the new peer was closed")
+	}
+	alice.expectSilence("removed", 200*time.Millisecond)
+	bob.expectSilence("peerLeft", 200*time.Millisecond)
+}
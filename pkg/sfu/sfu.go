@@ -2,58 +2,334 @@ package sfu
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/ice/v4"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
 	"github.com/pion/webrtc/v4"
 )
 
 // Errors
 var (
 	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionClosed   = errors.New("session closed")
 	ErrPeerNotFound    = errors.New("peer not found")
+	ErrTrackNotFound   = errors.New("track not found")
+	ErrNotJoined       = errors.New("not joined")
+	ErrAlreadyJoined   = errors.New("already joined")
+	ErrPeerExists      = errors.New("peer already exists")
+	ErrInvalidOffer    = errors.New("session description is not an offer")
+	ErrOfferCollision  = errors.New("offer collides with a pending server offer")
+	ErrNotConnected    = errors.New("peer has no signaling connection")
+	ErrRequestTimeout  = errors.New("client did not respond in time")
+	ErrNoPublisher     = errors.New("peer has no publisher connection")
+	ErrNoSubscriber    = errors.New("peer has no subscriber connection")
+
+	ErrSelfSubscribeDisabled = errors.New("subscribing to own media is disabled")
+
+	ErrTooManyCandidates = errors.New("too many candidates before the remote description")
+
+	ErrResumeTokenInvalid = errors.New("invalid resume token")
+	ErrReplayUnavailable  = errors.New("missed notifications are no longer available")
+
+	ErrNoSenderEncoding = errors.New("sender has no encoding")
 )
 
-// Config holds the SFU configuration.
-type Config struct {
-	ICEServers []webrtc.ICEServer
-}
+// Default ICE timeouts, matching pion's defaults.
+const (
+	// tcpMuxReadBufferSize is the number of packets buffered per ICE-TCP connection.
+	tcpMuxReadBufferSize = 8
+
+	defaultICEDisconnectedTimeout = 5 * time.Second
+	defaultICEFailedTimeout       = 25 * time.Second
+	defaultICEKeepaliveInterval   = 2 * time.Second
+)
 
 // SFU is the main Selective Forwarding Unit that manages sessions and WebRTC connections.
 type SFU struct {
 	config   Config
 	api      *webrtc.API
+	udpMux   ice.UDPMux
+	tcpMux   ice.TCPMux
+	turn     *turnServer
 	sessions map[string]*Session
 	mu       sync.RWMutex
 	upgrader websocket.Upgrader
+
+	onSessionCreated func(*Session)
+	onSessionClosed  func(*Session, SessionCloseReason)
 }
 
+// SessionCloseReason tells why a session was closed.
+type SessionCloseReason string
+
+// Session close reasons
+const (
+	// SessionCloseEmpty is used when the session stayed empty for session.emptytimeout.
+	SessionCloseEmpty SessionCloseReason = "empty"
+	// SessionCloseMaxDuration is used when the session reached session.maxduration.
+	SessionCloseMaxDuration SessionCloseReason = "max_duration"
+	// SessionCloseDeleted is used when the session was removed with DeleteSession.
+	SessionCloseDeleted SessionCloseReason = "deleted"
+	// SessionCloseShutdown is used when the SFU is closed.
+	SessionCloseShutdown SessionCloseReason = "shutdown"
+)
+
 // NewSFU creates a new SFU instance.
-func NewSFU(config Config) *SFU {
+func NewSFU(config Config) (*SFU, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	mediaEngine := &webrtc.MediaEngine{}
 	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
-		panic(err)
+		return nil, err
+	}
+	// Simulcast layers are told apart by their mid and RID header extensions.
+	// The default webrtc.API registers them along with its default interceptors,
+	// an API built with its own interceptor registry has to register them itself.
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return nil, err
 	}
 
-	return &SFU{
+	interceptorRegistry, err := newInterceptorRegistry(mediaEngine, config)
+	if err != nil {
+		return nil, err
+	}
+
+	settingEngine, err := newSettingEngine(config.WebRTC)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SFU{
 		config:   config,
-		api:      webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)),
 		sessions: make(map[string]*Session),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
 	}
+
+	// Share one UDP port across all peer connections
+	if config.WebRTC.SinglePort > 0 {
+		udpMux, err := ice.NewMultiUDPMuxFromPort(config.WebRTC.SinglePort)
+		if err != nil {
+			return nil, fmt.Errorf("webrtc.singleport: %w", err)
+		}
+		settingEngine.SetICEUDPMux(udpMux)
+		s.udpMux = udpMux
+		slog.Info("ICE UDP mux listening", slog.Int("port", config.WebRTC.SinglePort))
+	}
+
+	// Accept ICE-TCP on one port for clients that cannot use UDP
+	if config.WebRTC.TCPPort > 0 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.WebRTC.TCPPort})
+		if err != nil {
+			if closeErr := s.Close(); closeErr != nil {
+				slog.Warn("sfu close error", slog.String("error", closeErr.Error()))
+			}
+			return nil, fmt.Errorf("webrtc.tcpport: %w", err)
+		}
+		tcpMux := ice.NewTCPMuxDefault(ice.TCPMuxParams{
+			Listener:       listener,
+			ReadBufferSize: tcpMuxReadBufferSize,
+		})
+		settingEngine.SetICETCPMux(tcpMux)
+		settingEngine.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4,
+			webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4,
+			webrtc.NetworkTypeTCP6,
+		})
+		s.tcpMux = tcpMux
+		slog.Info("ICE TCP mux listening", slog.Int("port", config.WebRTC.TCPPort))
+	}
+
+	s.api = webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+	)
+
+	if config.Turn.Enabled {
+		turnServer, err := newTurnServer(config.Turn, config.WebRTC.Candidates.NAT1To1)
+		if err != nil {
+			if closeErr := s.Close(); closeErr != nil {
+				slog.Warn("sfu close error", slog.String("error", closeErr.Error()))
+			}
+			return nil, err
+		}
+		s.turn = turnServer
+		slog.Info("embedded TURN server started", slog.String("address", config.Turn.Address))
+		if !turnServer.shareable() {
+			slog.Warn("embedded TURN server is not handed to clients: set turn.auth.secret, or turn.auth.sharecredentials to send them the static credentials")
+		}
+	}
+
+	return s, nil
+}
+
+// newInterceptorRegistry builds the interceptor chain from the configuration and
+// adds the embedder's interceptors last.
+func newInterceptorRegistry(mediaEngine *webrtc.MediaEngine, config Config) (*interceptor.Registry, error) {
+	registry := &interceptor.Registry{}
+	interceptors := config.WebRTC.Interceptors
+
+	if interceptors.RTCPReports {
+		if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+			return nil, err
+		}
+	}
+
+	// The default video codecs already announce the nack feedback
+	if interceptors.NACKResponder {
+		responder, err := nack.NewResponderInterceptor()
+		if err != nil {
+			return nil, err
+		}
+		registry.Add(responder)
+	}
+	if interceptors.NACKGenerator {
+		generator, err := nack.NewGeneratorInterceptor()
+		if err != nil {
+			return nil, err
+		}
+		registry.Add(generator)
+	}
+
+	// The extension is negotiated for the subscribers' media as well, so the
+	// packets sent to them need transport-wide sequence numbers of their own
+	if interceptors.TWCC {
+		if err := webrtc.ConfigureTWCCSender(mediaEngine, registry); err != nil {
+			return nil, err
+		}
+		if err := webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, registry); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.RegisterInterceptors != nil {
+		if err := config.RegisterInterceptors(mediaEngine, registry); err != nil {
+			return nil, fmt.Errorf("register interceptors: %w", err)
+		}
+	}
+
+	return registry, nil
+}
+
+// newSettingEngine builds a SettingEngine from the WebRTC configuration.
+func newSettingEngine(config WebRTCConfig) (webrtc.SettingEngine, error) {
+	settingEngine := webrtc.SettingEngine{}
+
+	if len(config.PortRange) == 2 && config.SinglePort == 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(config.PortRange[0], config.PortRange[1]); err != nil {
+			return settingEngine, err
+		}
+	}
+
+	// Resolve the clients' mDNS candidates, but keep gathering plain host candidates:
+	// remote clients cannot resolve the server's .local names, and pion announces
+	// mDNS host candidates as UDP which would break ICE-TCP.
+	if config.MDNS {
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryOnly)
+	} else {
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	}
+
+	if len(config.Candidates.NAT1To1) > 0 {
+		if err := settingEngine.SetICEAddressRewriteRules(webrtc.ICEAddressRewriteRule{
+			External:        config.Candidates.NAT1To1,
+			AsCandidateType: webrtc.ICECandidateTypeHost,
+			Mode:            webrtc.ICEAddressRewriteReplace,
+		}); err != nil {
+			return settingEngine, err
+		}
+	}
+	settingEngine.SetLite(config.Candidates.ICELite)
+
+	timeouts := config.Timeouts
+	if timeouts.Disconnected > 0 || timeouts.Failed > 0 || timeouts.Keepalive > 0 {
+		settingEngine.SetICETimeouts(
+			secondsOrDefault(timeouts.Disconnected, defaultICEDisconnectedTimeout),
+			secondsOrDefault(timeouts.Failed, defaultICEFailedTimeout),
+			secondsOrDefault(timeouts.Keepalive, defaultICEKeepaliveInterval),
+		)
+	}
+
+	return settingEngine, nil
+}
+
+func secondsOrDefault(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Config returns the SFU configuration.
+func (s *SFU) Config() Config {
+	return s.config
 }
 
 // NewPeerConnection creates a new WebRTC peer connection with the configured ICE servers.
 func (s *SFU) NewPeerConnection() (*webrtc.PeerConnection, error) {
+	iceServers, err := s.iceServers(false)
+	if err != nil {
+		return nil, err
+	}
+
 	return s.api.NewPeerConnection(webrtc.Configuration{
-		ICEServers: s.config.ICEServers,
+		ICEServers:   iceServers,
+		SDPSemantics: sdpSemantics(s.config.WebRTC.SDPSemantics),
 	})
 }
 
+// ICEServers returns the ICE servers handed to clients: the configured ones and
+// the embedded TURN server if enabled. The TURN server is left out when it only
+// has static credentials, unless turn.auth.sharecredentials allows sending them.
+func (s *SFU) ICEServers() ([]webrtc.ICEServer, error) {
+	return s.iceServers(true)
+}
+
+func (s *SFU) iceServers(forClient bool) ([]webrtc.ICEServer, error) {
+	servers := make([]webrtc.ICEServer, 0, len(s.config.WebRTC.ICEServers)+1)
+	for _, server := range s.config.WebRTC.ICEServers {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		})
+	}
+
+	if s.turn != nil && (!forClient || s.turn.shareable()) {
+		server, err := s.turn.ICEServer()
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+func sdpSemantics(value string) webrtc.SDPSemantics {
+	switch value {
+	case SDPSemanticsPlanB:
+		return webrtc.SDPSemanticsPlanB
+	case SDPSemanticsUnifiedPlanWithFallback:
+		return webrtc.SDPSemanticsUnifiedPlanWithFallback
+	default:
+		return webrtc.SDPSemanticsUnifiedPlan
+	}
+}
+
 // Session Management
 
 // GetOrCreateSession returns an existing session or creates a new one.
@@ -67,9 +343,28 @@ func (s *SFU) GetOrCreateSession(id string) *Session {
 
 	session := newSession(id, s)
 	s.sessions[id] = session
+
+	if s.onSessionCreated != nil {
+		s.onSessionCreated(session)
+	}
 	return session
 }
 
+// OnSessionCreated sets a handler called when a session is created.
+// The handler must not call back into the SFU's session management.
+func (s *SFU) OnSessionCreated(f func(*Session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSessionCreated = f
+}
+
+// OnSessionClosed sets a handler called after a session was closed.
+func (s *SFU) OnSessionClosed(f func(*Session, SessionCloseReason)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSessionClosed = f
+}
+
 // GetSession returns a session by ID.
 func (s *SFU) GetSession(id string) (*Session, error) {
 	s.mu.RLock()
@@ -84,17 +379,90 @@ func (s *SFU) GetSession(id string) (*Session, error) {
 
 // DeleteSession removes and closes a session.
 func (s *SFU) DeleteSession(id string) {
+	s.mu.RLock()
+	session, ok := s.sessions[id]
+	s.mu.RUnlock()
+
+	if ok {
+		s.closeSession(session, SessionCloseDeleted)
+	}
+}
+
+// closeSession removes a session and closes it. Peers are told why unless the session is empty.
+// A session that is no longer registered, or that has peers again when closing because it
+// was empty, is left alone.
+func (s *SFU) closeSession(session *Session, reason SessionCloseReason) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	if current, ok := s.sessions[session.ID()]; !ok || current != session {
+		s.mu.Unlock()
+		return
+	}
+	if !session.markClosed(reason == SessionCloseEmpty) {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.sessions, session.ID())
+	onClosed := s.onSessionClosed
+	s.mu.Unlock()
 
-	if session, ok := s.sessions[id]; ok {
+	slog.Info("session closed", slog.String("sessionID", session.ID()), slog.String("reason", string(reason)))
+
+	if reason != SessionCloseEmpty {
+		session.Broadcast("", "sessionClosed", map[string]any{"reason": reason})
+	}
+	session.Close()
+
+	if onClosed != nil {
+		onClosed(session, reason)
+	}
+}
+
+// Close closes all sessions and releases the shared listeners and the embedded TURN server.
+func (s *SFU) Close() error {
+	s.mu.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.sessions = make(map[string]*Session)
+	onClosed := s.onSessionClosed
+	s.mu.Unlock()
+
+	for _, session := range sessions {
 		session.Close()
-		delete(s.sessions, id)
+		if onClosed != nil {
+			onClosed(session, SessionCloseShutdown)
+		}
 	}
+
+	var errs []error
+	if s.udpMux != nil {
+		errs = append(errs, s.udpMux.Close())
+	}
+	if s.tcpMux != nil {
+		errs = append(errs, s.tcpMux.Close())
+	}
+	if s.turn != nil {
+		errs = append(errs, s.turn.Close())
+	}
+	return errors.Join(errs...)
 }
 
 // HandleWebSocket handles incoming WebSocket connections for signaling.
+// When authentication is enabled, an access token may be passed as the token query
+// parameter; otherwise it must be sent with join.
 func (s *SFU) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	var claims *Claims
+	if token := r.URL.Query().Get("token"); token != "" && s.config.Auth.Enabled {
+		c, err := verifyToken(s.config.Auth.Key, token)
+		if err != nil {
+			slog.Info("websocket rejected", slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		claims = c
+	}
+
 	rawConn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Warn("websocket upgrade failed", slog.String("error", err.Error()))
@@ -108,6 +476,7 @@ func (s *SFU) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	handler := newSignalingHandler(s, conn)
+	handler := newSignalingHandler(s, conn, claims)
 	handler.run()
+	handler.close()
 }
@@ -0,0 +1,260 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestSFU creates an SFU from the default configuration changed by configure.
+func newTestSFU(t *testing.T, configure func(*Config)) *SFU {
+	t.Helper()
+
+	config := DefaultConfig()
+	if configure != nil {
+		configure(&config)
+	}
+	s, err := NewSFU(config)
+	if err != nil {
+		t.Fatalf("NewSFU: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("close sfu: %v", err)
+		}
+	})
+	return s
+}
+
+// testClient is the client end of a signaling connection.
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// newTestConn returns the server end of a WebSocket connection and its client.
+func newTestConn(t *testing.T) (*wsConn, *testClient) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn := newWSConn(<-conns)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = conn.Close()
+	})
+	return conn, &testClient{t: t, conn: client}
+}
+
+// testMessage is a message the server sent to a test client.
+type testMessage struct {
+	ID     uint64         `json:"id"`
+	Method string         `json:"method"`
+	Params map[string]any `json:"params"`
+	Seq    uint64         `json:"seq"`
+	Result map[string]any `json:"result"`
+}
+
+// read returns the next message, failing the test if none arrives in time.
+func (c *testClient) read() testMessage {
+	c.t.Helper()
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		c.t.Fatalf("set read deadline: %v", err)
+	}
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	var msg testMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatalf("decode %s: %v", data, err)
+	}
+	return msg
+}
+
+// readMethod skips messages until one with method arrives.
+func (c *testClient) readMethod(method string) testMessage {
+	c.t.Helper()
+
+	for {
+		if msg := c.read(); msg.Method == method {
+			return msg
+		}
+	}
+}
+
+// expectSilence fails the test if a message with method arrives within d.
+// The read deadline breaks the connection, so it must be the last read.
+func (c *testClient) expectSilence(method string, d time.Duration) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(d)
+	for {
+		if err := c.conn.SetReadDeadline(deadline); err != nil {
+			c.t.Fatalf("set read deadline: %v", err)
+		}
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg testMessage
+		if err := json.Unmarshal(data, &msg); err == nil && msg.Method == method {
+			c.t.Fatalf("unexpected %s: %s", method, data)
+		}
+	}
+}
+
+// addTestPeer adds a peer with all grants to a session.
+func addTestPeer(t *testing.T, session *Session, peerID string, conn *wsConn) *Peer {
+	t.Helper()
+
+	peer, err := session.AddPeer(peerID, conn, allGrants(), RoleBoth, ModeDual)
+	if err != nil {
+		t.Fatalf("AddPeer(%s): %v", peerID, err)
+	}
+	return peer
+}
+
+func TestCloseSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		withPeer   bool
+		reason     SessionCloseReason
+		wantClosed bool
+	}{
+		{"empty session", false, SessionCloseEmpty, true},
+		{"empty reason with peers", true, SessionCloseEmpty, false},
+		{"deleted with peers", true, SessionCloseDeleted, true},
+		{"max duration", true, SessionCloseMaxDuration, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, nil)
+			closed := make(chan SessionCloseReason, 2)
+			s.OnSessionClosed(func(_ *Session, reason SessionCloseReason) { closed <- reason })
+
+			session := s.GetOrCreateSession("room")
+			var peer *Peer
+			var client *testClient
+			if tt.withPeer {
+				var conn *wsConn
+				conn, client = newTestConn(t)
+				peer = addTestPeer(t, session, "alice", conn)
+			}
+
+			s.closeSession(session, tt.reason)
+
+			_, err := s.GetSession("room")
+			if !tt.wantClosed {
+				if err != nil {
+					t.Fatalf("GetSession = %v, want the session kept", err)
+				}
+				if len(closed) != 0 {
+					t.Fatal("OnSessionClosed called for a kept session")
+				}
+				if _, err := session.AddPeer("bob", nil, allGrants(), RoleBoth, ModeDual); err != nil {
+					t.Fatalf("AddPeer = %v, want the session open", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("GetSession = %v, want ErrSessionNotFound", err)
+			}
+			if reason := <-closed; reason != tt.reason {
+				t.Fatalf("OnSessionClosed reason = %s, want %s", reason, tt.reason)
+			}
+			if _, err := session.AddPeer("bob", nil, allGrants(), RoleBoth, ModeDual); !errors.Is(err, ErrSessionClosed) {
+				t.Fatalf("AddPeer = %v, want ErrSessionClosed", err)
+			}
+			if peer != nil {
+				msg := client.readMethod("sessionClosed")
+				if msg.Params["reason"] != string(tt.reason) {
+					t.Fatalf("sessionClosed = %+v", msg.Params)
+				}
+				if !peer.isClosed() {
+					t.Fatal("peer of the closed session was not closed")
+				}
+			}
+
+			// Closing again does nothing
+			s.closeSession(session, SessionCloseDeleted)
+			if len(closed) != 0 {
+				t.Fatal("OnSessionClosed called twice")
+			}
+		})
+	}
+}
+
+func TestCloseSessionUnregistered(t *testing.T) {
+	s := newTestSFU(t, nil)
+	closed := make(chan SessionCloseReason, 1)
+	s.OnSessionClosed(func(_ *Session, reason SessionCloseReason) { closed <- reason })
+
+	old := s.GetOrCreateSession("room")
+	s.closeSession(old, SessionCloseDeleted)
+	<-closed
+
+	// A late close of the old session leaves the new session with the same ID alone
+	current := s.GetOrCreateSession("room")
+	s.closeSession(old, SessionCloseMaxDuration)
+
+	if got, err := s.GetSession("room"); err != nil || got != current {
+		t.Fatalf("GetSession = %p, %v, want the new session", got, err)
+	}
+	if len(closed) != 0 {
+		t.Fatal("OnSessionClosed called for an unregistered session")
+	}
+}
+
+func TestMarkClosed(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	conn, _ := newTestConn(t)
+	addTestPeer(t, session, "alice", conn)
+
+	if session.markClosed(true) {
+		t.Fatal("markClosed(true) marked a session with peers")
+	}
+	if !session.markClosed(false) {
+		t.Fatal("markClosed(false) failed")
+	}
+	if session.markClosed(false) {
+		t.Fatal("markClosed marked a closed session")
+	}
+}
+
+// waitFor polls cond until it holds, failing the test with what if it does not in time.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,6 +2,7 @@ package sfu
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 
 	"github.com/gorilla/websocket"
@@ -13,16 +14,32 @@ const (
 	JSONRPCInvalidParams  = -32602
 	JSONRPCMethodNotFound = -32601
 	JSONRPCServerError    = -32000 // implementation-defined server error range (-32000 to -32099)
+	JSONRPCUnauthorized   = -32001 // missing or invalid access token
+	JSONRPCForbidden      = -32003 // access token does not allow the call
+)
+
+// Reasons reported in the data of authentication errors
+const (
+	reasonTokenRequired      = "token_required"
+	reasonTokenInvalid       = "token_invalid"
+	reasonSessionMismatch    = "session_mismatch"
+	reasonPeerMismatch       = "peer_mismatch"
+	reasonGrantRequired      = "grant_required"
+	reasonResumeTokenInvalid = "resume_token_invalid"
 )
 
 // signalingHandler handles JSON-RPC signaling for a single WebSocket connection.
 type signalingHandler struct {
 	sfu  *SFU
 	conn *wsConn
+	// claims of the access token presented by the client, nil until authenticated
+	claims *Claims
+	// peer this connection joined as, nil until joined
+	peer *Peer
 }
 
-func newSignalingHandler(sfu *SFU, conn *wsConn) *signalingHandler {
-	return &signalingHandler{sfu: sfu, conn: conn}
+func newSignalingHandler(sfu *SFU, conn *wsConn, claims *Claims) *signalingHandler {
+	return &signalingHandler{sfu: sfu, conn: conn, claims: claims}
 }
 
 func (h *signalingHandler) run() {
@@ -39,6 +56,12 @@ func (h *signalingHandler) run() {
 			continue
 		}
 
+		// A message without a method responds to a server request
+		if request.Method == "" {
+			h.handleResponse(message)
+			continue
+		}
+
 		response := h.handleRequest(&request)
 		if response != nil {
 			data, err := json.Marshal(response)
@@ -53,16 +76,49 @@ func (h *signalingHandler) run() {
 	}
 }
 
+// handleResponse passes a client response to the joined peer.
+func (h *signalingHandler) handleResponse(message []byte) {
+	var response rpcClientResponse
+	if err := json.Unmarshal(message, &response); err != nil {
+		slog.Debug("invalid response dropped", slog.String("error", err.Error()))
+		return
+	}
+	if h.peer == nil {
+		return
+	}
+	h.peer.handleResponse(&response)
+}
+
+// close handles the end of the connection. A joined peer stays in its session
+// for the disconnect grace period.
+func (h *signalingHandler) close() {
+	if h.peer != nil {
+		h.peer.Session().PeerDisconnected(h.peer, h.conn)
+	}
+}
+
 func (h *signalingHandler) handleRequest(req *rpcRequest) *rpcResponse {
+	// A peer the server removed, for example after its PeerConnection failed,
+	// no longer holds the connection, so the client can join again
+	if h.peer != nil && h.peer.isClosed() {
+		h.peer = nil
+	}
+
 	switch req.Method {
 	case "join":
 		return h.handleJoin(req)
 	case "subscribe":
 		return h.handleSubscribe(req)
+	case "unsubscribe":
+		return h.handleUnsubscribe(req)
 	case "candidate":
 		return h.handleCandidate(req)
+	case "offer":
+		return h.handleOffer(req)
 	case "answer":
 		return h.handleAnswer(req)
+	case "resume":
+		return h.handleResume(req)
 	case "leave":
 		return h.handleLeave(req)
 	case "setLayer":
@@ -80,56 +136,202 @@ func (h *signalingHandler) handleJoin(req *rpcRequest) *rpcResponse {
 		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
 	}
 
-	session := h.sfu.GetOrCreateSession(params.SessionID)
-	peer, err := session.AddPeer(params.PeerID, h.conn)
+	if h.peer != nil {
+		return errorResponse(req.ID, JSONRPCServerError, ErrAlreadyJoined.Error())
+	}
+
+	if resp := h.useToken(req, params.Token); resp != nil {
+		return resp
+	}
+	if resp := h.authorize(req, params.SessionID, params.PeerID); resp != nil {
+		return resp
+	}
+
+	role := params.Role
+	if role == "" {
+		role = RoleBoth
+	}
+	if !role.Valid() {
+		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid role")
+	}
+	mode := params.Mode
+	if mode == "" {
+		mode = ModeDual
+	}
+	if !mode.Valid() {
+		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid mode")
+	}
+	// A single connection is always opened by the client's offer
+	answers := role.publishes() || mode == ModeSingle
+	if !answers && params.Offer.SDP != "" {
+		return errorResponse(req.ID, JSONRPCInvalidParams, "offer is not allowed for a subscriber")
+	}
+
+	grants := h.grants()
+	if answers {
+		if resp := checkPublishGrant(req, grants, params.Offer); resp != nil {
+			return resp
+		}
+	}
+
+	// The client uses the same servers, including the embedded TURN server
+	iceServers, err := h.sfu.ICEServers()
 	if err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
 
-	answer, err := peer.HandleOffer(params.Offer)
+	session := h.sfu.GetOrCreateSession(params.SessionID)
+	peer, err := session.AddPeer(params.PeerID, h.conn, grants, role, mode)
+	if errors.Is(err, ErrSessionClosed) {
+		// The session closed after it was looked up; join a new one
+		session = h.sfu.GetOrCreateSession(params.SessionID)
+		peer, err = session.AddPeer(params.PeerID, h.conn, grants, role, mode)
+	}
 	if err != nil {
+		session.scheduleEmptyClose()
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
 
+	// A subscriber has no publisher connection to answer for until it offers later
+	var answer *webrtc.SessionDescription
+	if answers {
+		answer, err = peer.HandleOffer(params.Offer)
+		if err != nil {
+			// Keep the connection open so the client can retry
+			peer.unbind()
+			session.removeJoiningPeer(peer)
+			return errorResponse(req.ID, JSONRPCServerError, err.Error())
+		}
+	}
+	h.peer = peer
+
+	peers := session.snapshot(peer.ID())
+	session.Broadcast(peer.ID(), "peerJoined", map[string]any{"peerId": peer.ID()})
 	go session.NotifyExistingTracks(peer)
 
-	return successResponse(req.ID, joinResult{Answer: *answer})
+	autoSubscribe := h.sfu.config.Session.AutoSubscribe
+	if params.AutoSubscribe != nil {
+		autoSubscribe = *params.AutoSubscribe
+	}
+	if autoSubscribe && grants.CanSubscribe && role.subscribes() {
+		go session.AutoSubscribe(peer)
+	}
+
+	// A subscriber connection of its own is offered right away, so that its
+	// data channel opens and the connect timer runs before any subscription
+	if subscriber := peer.getSubscriber(); subscriber != nil && mode == ModeDual {
+		go func() {
+			if err := subscriber.Negotiate(); err != nil {
+				slog.Warn("initial subscriber negotiation failed", slog.String("peerID", peer.ID()), slog.String("error", err.Error()))
+			}
+		}()
+	}
+
+	return successResponse(req.ID, joinResult{
+		Answer:      answer,
+		ResumeToken: peer.ResumeToken(),
+		Peers:       peers,
+		ICEServers:  iceServerInfos(iceServers),
+	})
 }
 
-func (h *signalingHandler) handleSubscribe(req *rpcRequest) *rpcResponse {
-	var params subscribeParams
+func (h *signalingHandler) handleResume(req *rpcRequest) *rpcResponse {
+	var params resumeParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
 	}
 
+	if h.peer != nil {
+		return errorResponse(req.ID, JSONRPCServerError, ErrAlreadyJoined.Error())
+	}
+
+	if resp := h.useToken(req, params.Token); resp != nil {
+		return resp
+	}
+	if resp := h.authorize(req, params.SessionID, params.PeerID); resp != nil {
+		return resp
+	}
+
 	session, err := h.sfu.GetSession(params.SessionID)
 	if err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
 
-	if err := session.Subscribe(params.PeerID, params.TargetPeerID); err != nil {
+	peer, err := session.GetPeer(params.PeerID)
+	if err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
 
-	return successResponse(req.ID, map[string]bool{"success": true})
+	// The peer keeps the grants it joined with, so the token must still allow them
+	if grant := h.grants().missing(peer.Grants()); grant != "" {
+		return authErrorResponse(req.ID, JSONRPCForbidden, "token does not grant "+grant, reasonGrantRequired, grant)
+	}
+
+	// The response is written by the peer, ahead of the replayed notifications
+	data, err := json.Marshal(successResponse(req.ID, map[string]bool{"success": true}))
+	if err != nil {
+		return errorResponse(req.ID, JSONRPCServerError, err.Error())
+	}
+
+	if err := peer.resume(h.conn, params.ResumeToken, params.LastSeq, data); err != nil {
+		if errors.Is(err, ErrResumeTokenInvalid) {
+			return authErrorResponse(req.ID, JSONRPCUnauthorized, err.Error(), reasonResumeTokenInvalid, "")
+		}
+		return errorResponse(req.ID, JSONRPCServerError, err.Error())
+	}
+	h.peer = peer
+
+	slog.Info("peer resumed", slog.String("sessionID", session.ID()), slog.String("peerID", peer.ID()))
+	return nil
 }
 
-func (h *signalingHandler) handleCandidate(req *rpcRequest) *rpcResponse {
-	var params candidateParams
+func (h *signalingHandler) handleSubscribe(req *rpcRequest) *rpcResponse {
+	var params subscribeParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
 	}
 
-	session, err := h.sfu.GetSession(params.SessionID)
-	if err != nil {
+	peer, resp := h.joinedPeer(req, params.SessionID, params.PeerID, GrantSubscribe)
+	if resp != nil {
+		return resp
+	}
+
+	if err := peer.Session().Subscribe(peer.ID(), params.TargetPeerID, params.TrackIDs...); err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
 
-	peer, err := session.GetPeer(params.PeerID)
-	if err != nil {
+	return successResponse(req.ID, map[string]bool{"success": true})
+}
+
+func (h *signalingHandler) handleUnsubscribe(req *rpcRequest) *rpcResponse {
+	var params subscribeParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
+	}
+
+	peer, resp := h.joinedPeer(req, params.SessionID, params.PeerID, "")
+	if resp != nil {
+		return resp
+	}
+
+	if err := peer.Session().Unsubscribe(peer.ID(), params.TargetPeerID, params.TrackIDs...); err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
 
+	return successResponse(req.ID, map[string]bool{"success": true})
+}
+
+func (h *signalingHandler) handleCandidate(req *rpcRequest) *rpcResponse {
+	var params candidateParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
+	}
+
+	peer, resp := h.joinedPeer(req, params.SessionID, params.PeerID, "")
+	if resp != nil {
+		return resp
+	}
+
 	if err := peer.AddICECandidate(params.Candidate, params.Target); err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
@@ -137,22 +339,42 @@ func (h *signalingHandler) handleCandidate(req *rpcRequest) *rpcResponse {
 	return successResponse(req.ID, map[string]bool{"success": true})
 }
 
-func (h *signalingHandler) handleAnswer(req *rpcRequest) *rpcResponse {
-	var params answerParams
+// handleOffer renegotiates the publisher connection, e.g. to add or remove tracks
+// or data channels after join.
+func (h *signalingHandler) handleOffer(req *rpcRequest) *rpcResponse {
+	var params offerParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
 	}
 
-	session, err := h.sfu.GetSession(params.SessionID)
-	if err != nil {
-		return errorResponse(req.ID, JSONRPCServerError, err.Error())
+	peer, resp := h.joinedPeer(req, params.SessionID, params.PeerID, "")
+	if resp != nil {
+		return resp
 	}
 
-	peer, err := session.GetPeer(params.PeerID)
+	if resp := checkPublishGrant(req, peer.Grants(), params.Offer); resp != nil {
+		return resp
+	}
+
+	answer, err := peer.HandleOffer(params.Offer)
 	if err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
 
+	return successResponse(req.ID, offerResult{Answer: *answer})
+}
+
+func (h *signalingHandler) handleAnswer(req *rpcRequest) *rpcResponse {
+	var params answerParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
+	}
+
+	peer, resp := h.joinedPeer(req, params.SessionID, params.PeerID, "")
+	if resp != nil {
+		return resp
+	}
+
 	if err := peer.HandleAnswer(params.Answer); err != nil {
 		return errorResponse(req.ID, JSONRPCServerError, err.Error())
 	}
@@ -166,12 +388,28 @@ func (h *signalingHandler) handleLeave(req *rpcRequest) *rpcResponse {
 		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
 	}
 
-	session, err := h.sfu.GetSession(params.SessionID)
-	if err != nil {
-		return errorResponse(req.ID, JSONRPCServerError, err.Error())
+	peer, resp := h.joinedPeer(req, params.SessionID, "", "")
+	if resp != nil {
+		return resp
 	}
+	session := peer.Session()
 
-	session.RemovePeer(params.PeerID)
+	// Admins may remove other peers of their session
+	if params.PeerID != "" && params.PeerID != peer.ID() {
+		if !peer.Grants().Admin {
+			return authErrorResponse(req.ID, JSONRPCForbidden, "connection is bound to another peer", reasonPeerMismatch, GrantAdmin)
+		}
+		if _, err := session.GetPeer(params.PeerID); err != nil {
+			return errorResponse(req.ID, JSONRPCServerError, err.Error())
+		}
+		session.removePeer(params.PeerID, peerLeftKicked)
+		return successResponse(req.ID, map[string]bool{"success": true})
+	}
+
+	// Keep the connection open so the client can join again
+	peer.unbind()
+	session.RemovePeer(peer.ID())
+	h.peer = nil
 	return successResponse(req.ID, map[string]bool{"success": true})
 }
 
@@ -181,17 +419,12 @@ func (h *signalingHandler) handleSetLayer(req *rpcRequest) *rpcResponse {
 		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
 	}
 
-	slog.Info("[Signaling] setLayer", "session", params.SessionID, "peer", params.PeerID, "trackId", params.TrackID, "layer", params.Layer)
-
-	session, err := h.sfu.GetSession(params.SessionID)
-	if err != nil {
-		return errorResponse(req.ID, JSONRPCServerError, err.Error())
+	peer, resp := h.joinedPeer(req, params.SessionID, params.PeerID, GrantSubscribe)
+	if resp != nil {
+		return resp
 	}
 
-	peer, err := session.GetPeer(params.PeerID)
-	if err != nil {
-		return errorResponse(req.ID, JSONRPCServerError, err.Error())
-	}
+	slog.Info("[Signaling] setLayer", "session", peer.Session().ID(), "peer", peer.ID(), "trackId", params.TrackID, "layer", params.Layer)
 
 	peer.SetLayer(params.TrackID, params.Layer)
 
@@ -201,17 +434,12 @@ func (h *signalingHandler) handleSetLayer(req *rpcRequest) *rpcResponse {
 func (h *signalingHandler) handleGetLayer(req *rpcRequest) *rpcResponse {
 	var params getLayerParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
-		return errorResponse(req.ID, -32602, "Invalid params")
-	}
-
-	session, err := h.sfu.GetSession(params.SessionID)
-	if err != nil {
-		return errorResponse(req.ID, -32000, err.Error())
+		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid params")
 	}
 
-	peer, err := session.GetPeer(params.PeerID)
-	if err != nil {
-		return errorResponse(req.ID, -32000, err.Error())
+	peer, resp := h.joinedPeer(req, params.SessionID, params.PeerID, GrantSubscribe)
+	if resp != nil {
+		return resp
 	}
 
 	current, target, ok := peer.GetLayer(params.TrackID)
@@ -225,6 +453,87 @@ func (h *signalingHandler) handleGetLayer(req *rpcRequest) *rpcResponse {
 	})
 }
 
+// useToken verifies an access token passed with a call. It replaces the token
+// of the WebSocket URL and is ignored when authentication is disabled.
+func (h *signalingHandler) useToken(req *rpcRequest, token string) *rpcResponse {
+	if token == "" || !h.sfu.config.Auth.Enabled {
+		return nil
+	}
+
+	claims, err := verifyToken(h.sfu.config.Auth.Key, token)
+	if err != nil {
+		return authErrorResponse(req.ID, JSONRPCUnauthorized, err.Error(), reasonTokenInvalid, "")
+	}
+	h.claims = claims
+	return nil
+}
+
+// authorize checks that the client's access token allows joining as peerID in sessionID.
+// It returns nil if the join is allowed and always allows it when authentication is disabled.
+func (h *signalingHandler) authorize(req *rpcRequest, sessionID, peerID string) *rpcResponse {
+	if !h.sfu.config.Auth.Enabled {
+		return nil
+	}
+
+	if h.claims == nil {
+		return authErrorResponse(req.ID, JSONRPCUnauthorized, ErrTokenRequired.Error(), reasonTokenRequired, "")
+	}
+	if sessionID != h.claims.SessionID {
+		return authErrorResponse(req.ID, JSONRPCForbidden, "token is not valid for this session", reasonSessionMismatch, "")
+	}
+	if peerID != h.claims.PeerID {
+		return authErrorResponse(req.ID, JSONRPCForbidden, "token is not valid for this peer", reasonPeerMismatch, "")
+	}
+	return nil
+}
+
+// checkPublishGrant rejects an offer that sends media unless the grants allow publishing.
+// Offers that only receive are always allowed.
+func checkPublishGrant(req *rpcRequest, grants Grants, offer webrtc.SessionDescription) *rpcResponse {
+	if grants.CanPublish {
+		return nil
+	}
+
+	publishes, err := sendsMedia(offer)
+	if err != nil {
+		return errorResponse(req.ID, JSONRPCInvalidParams, "Invalid offer")
+	}
+	if publishes {
+		return authErrorResponse(req.ID, JSONRPCForbidden, "publishing is not allowed", reasonGrantRequired, GrantPublish)
+	}
+	return nil
+}
+
+// joinedPeer returns the peer this connection joined as, after checking the grant.
+// sessionID and peerID may be omitted; if given, they must match the joined peer.
+func (h *signalingHandler) joinedPeer(req *rpcRequest, sessionID, peerID, grant string) (*Peer, *rpcResponse) {
+	peer := h.peer
+	if peer == nil {
+		return nil, errorResponse(req.ID, JSONRPCServerError, ErrNotJoined.Error())
+	}
+
+	if sessionID != "" && sessionID != peer.Session().ID() {
+		return nil, authErrorResponse(req.ID, JSONRPCForbidden, "connection is bound to another session", reasonSessionMismatch, "")
+	}
+	if peerID != "" && peerID != peer.ID() {
+		return nil, authErrorResponse(req.ID, JSONRPCForbidden, "connection is bound to another peer", reasonPeerMismatch, "")
+	}
+
+	if !peer.Grants().Has(grant) {
+		return nil, authErrorResponse(req.ID, JSONRPCForbidden, req.Method+" is not allowed", reasonGrantRequired, grant)
+	}
+	return peer, nil
+}
+
+// grants returns the grants of the client's access token, or all grants when
+// authentication is disabled.
+func (h *signalingHandler) grants() Grants {
+	if h.claims == nil {
+		return allGrants()
+	}
+	return h.claims.Grants
+}
+
 func (h *signalingHandler) sendError(id any, code int, message string) {
 	response := errorResponse(id, code, message)
 	data, err := json.Marshal(response)
@@ -256,12 +565,40 @@ type rpcResponse struct {
 type rpcError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// authErrorData describes why a call was rejected by authentication.
+type authErrorData struct {
+	Reason string `json:"reason"`
+	Grant  string `json:"grant,omitempty"`
+}
+
+// rpcServerRequest is a request sent by the server to the client.
+type rpcServerRequest struct {
+	JSONRPC string         `json:"jsonrpc"`
+	ID      uint64         `json:"id"`
+	Method  string         `json:"method"`
+	Params  map[string]any `json:"params"`
+}
+
+// rpcClientResponse is the client's response to a server request.
+type rpcClientResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
 }
 
 type rpcNotification struct {
 	JSONRPC string         `json:"jsonrpc"`
 	Method  string         `json:"method"`
 	Params  map[string]any `json:"params"`
+	// Seq numbers the notifications sent to a peer, for replay on resume
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 func successResponse(id any, result any) *rpcResponse {
@@ -272,22 +609,83 @@ func errorResponse(id any, code int, message string) *rpcResponse {
 	return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
 }
 
+func authErrorResponse(id any, code int, message, reason, grant string) *rpcResponse {
+	return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{
+		Code:    code,
+		Message: message,
+		Data:    authErrorData{Reason: reason, Grant: grant},
+	}}
+}
+
 // RPC Params and Results
 
 type joinParams struct {
 	SessionID string                    `json:"sessionId"`
 	PeerID    string                    `json:"peerId"`
+	Role      Role                      `json:"role,omitempty"`
+	Mode      Mode                      `json:"mode,omitempty"`
 	Offer     webrtc.SessionDescription `json:"offer"`
+	Token     string                    `json:"token,omitempty"`
+	// AutoSubscribe overrides session.autosubscribe for this peer
+	AutoSubscribe *bool `json:"autoSubscribe,omitempty"`
 }
 
 type joinResult struct {
-	Answer webrtc.SessionDescription `json:"answer"`
+	// Answer is omitted for a subscriber in dual mode
+	Answer      *webrtc.SessionDescription `json:"answer,omitempty"`
+	ResumeToken string                     `json:"resumeToken"`
+	// Peers lists the other peers in the session and their tracks
+	Peers []peerInfo `json:"peers"`
+	// ICEServers are the STUN and TURN servers with generated TURN credentials
+	ICEServers []iceServerInfo `json:"iceServers"`
+}
+
+type iceServerInfo struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// iceServerInfos converts ICE servers to their JSON form for clients.
+func iceServerInfos(servers []webrtc.ICEServer) []iceServerInfo {
+	infos := make([]iceServerInfo, 0, len(servers))
+	for _, server := range servers {
+		credential, _ := server.Credential.(string)
+		infos = append(infos, iceServerInfo{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: credential,
+		})
+	}
+	return infos
+}
+
+type peerInfo struct {
+	PeerID string      `json:"peerId"`
+	Tracks []trackInfo `json:"tracks"`
+}
+
+type trackInfo struct {
+	TrackID  string `json:"trackId"`
+	StreamID string `json:"streamId"`
+	Kind     string `json:"kind"`
+}
+
+type resumeParams struct {
+	SessionID   string `json:"sessionId"`
+	PeerID      string `json:"peerId"`
+	ResumeToken string `json:"resumeToken"`
+	// LastSeq is the seq of the last notification the client received
+	LastSeq uint64 `json:"lastSeq,omitempty"`
+	Token   string `json:"token,omitempty"`
 }
 
 type subscribeParams struct {
 	SessionID    string `json:"sessionId"`
 	PeerID       string `json:"peerId"`
 	TargetPeerID string `json:"targetPeerId"`
+	// TrackIDs limits the call to these tracks of the target peer
+	TrackIDs []string `json:"trackIds,omitempty"`
 }
 
 type candidateParams struct {
@@ -297,6 +695,16 @@ type candidateParams struct {
 	Target    string                  `json:"target"`
 }
 
+type offerParams struct {
+	SessionID string                    `json:"sessionId"`
+	PeerID    string                    `json:"peerId"`
+	Offer     webrtc.SessionDescription `json:"offer"`
+}
+
+type offerResult struct {
+	Answer webrtc.SessionDescription `json:"answer"`
+}
+
 type answerParams struct {
 	SessionID string                    `json:"sessionId"`
 	PeerID    string                    `json:"peerId"`
@@ -0,0 +1,147 @@
+package sfu
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// clientOffer returns an offer from a client that publishes an audio track.
+func clientOffer(t *testing.T) webrtc.SessionDescription {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	}); err != nil {
+		t.Fatalf("AddTransceiverFromKind: %v", err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer: %v", err)
+	}
+	return offer
+}
+
+// join sends a join request through h and returns the response. Params without
+// an offer are given as a map, since an empty description cannot be encoded.
+func join(t *testing.T, h *signalingHandler, params any) *rpcResponse {
+	t.Helper()
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("marshal join params: %v", err)
+	}
+	return h.handleRequest(&rpcRequest{JSONRPC: "2.0", ID: 1, Method: "join", Params: data})
+}
+
+func TestJoinFailedOfferIsSilent(t *testing.T) {
+	s := newTestSFU(t, nil)
+	session := s.GetOrCreateSession("room")
+	bobConn, bob := newTestConn(t)
+	addTestPeer(t, session, "bob", bobConn)
+
+	conn, _ := newTestConn(t)
+	h := newSignalingHandler(s, conn, nil)
+
+	// The offer passes the grant check but has no ICE credentials, so it cannot be answered
+	if resp := join(t, h, joinParams{SessionID: "room", PeerID: "alice", Offer: testOffer("audio sendonly")}); resp.Error == nil {
+		t.Fatalf("join with a broken offer = %+v, want an error", resp)
+	}
+	if h.peer != nil {
+		t.Fatal("failed join bound the peer to the connection")
+	}
+	if _, err := session.GetPeer("alice"); err == nil {
+		t.Fatal("peer of the failed join kept in the session")
+	}
+	// The client can retry on the same connection
+	resp := join(t, h, joinParams{SessionID: "room", PeerID: "alice", Offer: clientOffer(t)})
+	if resp.Error != nil {
+		t.Fatalf("rejoin = %+v", resp.Error)
+	}
+	if h.peer == nil || h.peer.ID() != "alice" {
+		t.Fatal("rejoin did not bind the peer")
+	}
+
+	// The room was never told about the failed join, so the first news of
+	// alice is that it joined
+	for {
+		msg := bob.read()
+		if msg.Method == "peerLeft" || msg.Method == "trackRemoved" {
+			t.Fatalf("room notified about the failed join: %+v", msg)
+		}
+		if msg.Method == "peerJoined" {
+			if msg.Params["peerId"] != "alice" {
+				t.Fatalf("peerJoined = %+v", msg.Params)
+			}
+			break
+		}
+	}
+}
+
+func TestJoinSubscriberConnectTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// answer responds to the offer sent at join and connects
+		answer      bool
+		wantRemoved bool
+	}{
+		{"offer answered", true, false},
+		{"offer not answered", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, func(c *Config) { c.WebRTC.Timeouts.SubscriberConnect = 3 })
+			conn, ws := newTestConn(t)
+			h := newSignalingHandler(s, conn, nil)
+
+			// The viewer never subscribes, but its connection is offered at join
+			params := map[string]any{"sessionId": "room", "peerId": "alice", "role": RoleSubscriber, "autoSubscribe": false}
+			if resp := join(t, h, params); resp.Error != nil {
+				t.Fatalf("join = %+v", resp.Error)
+			}
+			id, offer := ws.readOffer()
+
+			if !tt.wantRemoved {
+				client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+				if err != nil {
+					t.Fatalf("NewPeerConnection: %v", err)
+				}
+				t.Cleanup(func() { _ = client.Close() })
+				if err := client.SetRemoteDescription(offer); err != nil {
+					t.Fatalf("SetRemoteDescription: %v", err)
+				}
+				answer, err := client.CreateAnswer(nil)
+				if err != nil {
+					t.Fatalf("CreateAnswer: %v", err)
+				}
+				if err := client.SetLocalDescription(answer); err != nil {
+					t.Fatalf("SetLocalDescription: %v", err)
+				}
+				respondAnswer(t, h.peer, id, answer)
+
+				subscriber := h.peer.getSubscriber()
+				addServerCandidates(t, client, subscriber.pc)
+				waitFor(t, "the subscriber to connect", subscriber.pc.connected.Load)
+				time.Sleep(3500 * time.Millisecond)
+				if h.peer.isClosed() {
+					t.Fatal("peer removed after the subscriber connected")
+				}
+				return
+			}
+
+			msg := ws.readMethod("removed")
+			if msg.Params["reason"] != peerLeftTimeout || msg.Params["target"] != "subscriber" {
+				t.Fatalf("removed = %+v", msg.Params)
+			}
+		})
+	}
+}
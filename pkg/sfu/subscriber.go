@@ -1,8 +1,11 @@
 package sfu
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v4"
 )
@@ -10,25 +13,26 @@ import (
 // Subscriber handles the subscribing (downstream) connection to a client.
 type Subscriber struct {
 	peer        *Peer
-	pc          *webrtc.PeerConnection
+	pc          *peerConnection
 	downTracks  map[string]*DownTrack
 	routers     map[*Router]struct{}
 	dataChannel *webrtc.DataChannel
 	mu          sync.RWMutex
 	closed      bool
+	// transceivers are the sendonly transceivers added on a shared connection
+	transceivers []*webrtc.RTPTransceiver
 
 	// Negotiation state
 	negotiating bool
 	needsOffer  bool
+	// restartICE makes the next offer restart ICE
+	restartICE bool
+	// cancelOffer stops waiting for the answer to the pending offer
+	cancelOffer context.CancelFunc
 	negMu       sync.Mutex
 }
 
-func newSubscriber(peer *Peer) (*Subscriber, error) {
-	pc, err := peer.session.sfu.NewPeerConnection()
-	if err != nil {
-		return nil, err
-	}
-
+func newSubscriber(peer *Peer, pc *peerConnection) (*Subscriber, error) {
 	s := &Subscriber{
 		peer:       peer,
 		pc:         pc,
@@ -51,16 +55,26 @@ func newSubscriber(peer *Peer) (*Subscriber, error) {
 		slog.Info("[Subscriber] Data channel closed", slog.String("peerID", peer.id))
 	})
 
+	// A shared connection is watched by the publisher
+	if pc.shared {
+		return s, nil
+	}
+
 	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
 		if err := peer.SendCandidate(c, "subscriber"); err != nil {
 			slog.Warn("send candidate (subscriber) failed", slog.String("error", err.Error()))
 		}
 	})
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
-			if err := s.Close(); err != nil {
-				slog.Warn("subscriber close error", slog.String("error", err.Error()))
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			pc.connected.Store(true)
+		case webrtc.PeerConnectionStateDisconnected:
+			if err := s.RestartICE(); err != nil {
+				slog.Warn("ICE restart failed", slog.String("error", err.Error()))
 			}
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			peer.session.removeFailedPeer(peer, peerLeftFailed, "subscriber", state)
 		}
 	})
 
@@ -69,7 +83,7 @@ func newSubscriber(peer *Peer) (*Subscriber, error) {
 
 // PeerConnection returns the underlying WebRTC peer connection.
 func (s *Subscriber) PeerConnection() *webrtc.PeerConnection {
-	return s.pc
+	return s.pc.PeerConnection
 }
 
 // Subscribe subscribes to a router to receive its tracks.
@@ -88,6 +102,89 @@ func (s *Subscriber) Subscribe(router *Router) error {
 	return s.Negotiate()
 }
 
+// SubscribeTracks subscribes to specific tracks of a router.
+// Tracks the router publishes later are not subscribed.
+func (s *Subscriber) SubscribeTracks(router *Router, trackIDs []string) error {
+	for _, trackID := range trackIDs {
+		if _, ok := router.GetTrack(trackID); !ok {
+			return ErrTrackNotFound
+		}
+	}
+
+	slog.Info("[Subscriber] Subscribing to tracks", "routerID", router.ID(), "trackIDs", trackIDs)
+
+	for _, trackID := range trackIDs {
+		if err := router.SubscribeTrack(s, trackID); err != nil {
+			slog.Warn("[Subscriber] Error subscribing to track", "error", err, "trackID", trackID)
+			return err
+		}
+	}
+
+	return s.Negotiate()
+}
+
+// Unsubscribe unsubscribes from a router and removes all of its tracks.
+func (s *Subscriber) Unsubscribe(router *Router) {
+	s.mu.Lock()
+	delete(s.routers, router)
+	s.mu.Unlock()
+
+	slog.Info("[Subscriber] Unsubscribing from router", "routerID", router.ID())
+	router.Unsubscribe(s)
+}
+
+// UnsubscribeTracks removes specific tracks of a router.
+// While subscribed to the whole router, its future tracks are still added.
+func (s *Subscriber) UnsubscribeTracks(router *Router, trackIDs []string) error {
+	for _, trackID := range trackIDs {
+		if _, ok := router.GetTrack(trackID); !ok {
+			return ErrTrackNotFound
+		}
+	}
+
+	slog.Info("[Subscriber] Unsubscribing from tracks", "routerID", router.ID(), "trackIDs", trackIDs)
+
+	for _, trackID := range trackIDs {
+		if err := router.UnsubscribeTrack(s, trackID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSender adds a track to the connection. On a shared connection it only uses
+// sendonly transceivers, so that downtracks never share a transceiver with the
+// client's published media, and reuses the ones freed by removed downtracks.
+// s.mu must be held.
+func (s *Subscriber) addSender(track webrtc.TrackLocal) (*webrtc.RTPSender, error) {
+	if !s.pc.shared {
+		return s.pc.AddTrack(track)
+	}
+
+	for _, transceiver := range s.transceivers {
+		if transceiver.Sender() != nil || transceiver.Kind() != track.Kind() {
+			continue
+		}
+		sender, err := s.peer.session.sfu.api.NewRTPSender(track, s.pc.SCTP().Transport())
+		if err != nil {
+			return nil, err
+		}
+		if err := transceiver.SetSender(sender, track); err != nil {
+			return nil, err
+		}
+		return sender, nil
+	}
+
+	transceiver, err := s.pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.transceivers = append(s.transceivers, transceiver)
+	return transceiver.Sender(), nil
+}
+
 // AddDownTrack adds a downtrack for a track receiver.
 func (s *Subscriber) AddDownTrack(track *TrackReceiver) error {
 	s.mu.Lock()
@@ -123,6 +220,29 @@ func (s *Subscriber) AddDownTrack(track *TrackReceiver) error {
 	return nil
 }
 
+// removeDownTrack removes a closed downtrack from the connection and renegotiates.
+// It does nothing once the subscriber is closed.
+func (s *Subscriber) removeDownTrack(dt *DownTrack) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	trackID := dt.TrackReceiver().TrackID()
+	if s.downTracks[trackID] == dt {
+		delete(s.downTracks, trackID)
+	}
+	s.mu.Unlock()
+
+	if err := s.pc.RemoveTrack(dt.sender); err != nil {
+		return err
+	}
+
+	slog.Info("[Subscriber] Removed downtrack", "trackID", trackID)
+	return s.Negotiate()
+}
+
 // GetDownTrack returns the downtrack for a track ID.
 func (s *Subscriber) GetDownTrack(trackID string) *DownTrack {
 	s.mu.RLock()
@@ -157,8 +277,22 @@ func (s *Subscriber) GetLayer(trackID string) (current, target string, ok bool)
 }
 
 // AddICECandidate adds an ICE candidate to the subscriber connection.
+// Candidates received before the answer, which races with them, are added once
+// it is applied.
 func (s *Subscriber) AddICECandidate(candidate webrtc.ICECandidateInit) error {
-	return s.pc.AddICECandidate(candidate)
+	return s.pc.addICECandidate(candidate)
+}
+
+// RestartICE renegotiates with an offer that restarts ICE, keeping the
+// downtracks in place so that media resumes on the new candidate pair.
+func (s *Subscriber) RestartICE() error {
+	slog.Info("[Subscriber] Restarting ICE", slog.String("peerID", s.peer.id))
+
+	s.negMu.Lock()
+	s.restartICE = true
+	s.negMu.Unlock()
+
+	return s.Negotiate()
 }
 
 // Negotiate initiates SDP negotiation with the client.
@@ -190,26 +324,155 @@ func (s *Subscriber) Negotiate() error {
 func (s *Subscriber) doNegotiate() error {
 	slog.Debug("[Subscriber] Creating offer")
 
-	offer, err := s.pc.CreateOffer(nil)
+	// The offer is sent under the lock, so that on a shared connection it
+	// reaches the client before the rejection of a colliding client offer
+	s.pc.signalingMu.Lock()
+	offer, pending, err := s.offer()
 	if err != nil {
+		s.pc.signalingMu.Unlock()
 		s.resetNegotiationState()
 		return err
 	}
+	if pending {
+		// Changes made since the pending offer need an offer of their own
+		s.negMu.Lock()
+		s.needsOffer = true
+		s.negMu.Unlock()
+	}
 
-	if err := s.pc.SetLocalDescription(offer); err != nil {
+	slog.Debug("[Subscriber] Sending offer to peer")
+	id, err := s.peer.sendRequest("offer", map[string]any{"offer": offer})
+	s.pc.signalingMu.Unlock()
+	if err != nil {
 		s.resetNegotiationState()
+		if errors.Is(err, ErrNotConnected) {
+			slog.Debug("[Subscriber] Peer disconnected, offer is sent on resume")
+			return nil
+		}
 		return err
 	}
 
-	slog.Debug("[Subscriber] Sending offer to peer")
-	return s.peer.SendOffer(offer)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.negMu.Lock()
+	s.cancelOffer = cancel
+	s.negMu.Unlock()
+
+	go s.awaitAnswer(ctx, id, offer)
+	return nil
+}
+
+// offer returns the pending offer if the client has not answered it yet, or
+// creates a new one. A pending offer cannot be replaced, so it is sent again
+// and pending is set; a requested ICE restart waits for the next offer.
+// s.pc.signalingMu must be held.
+func (s *Subscriber) offer() (offer webrtc.SessionDescription, pending bool, err error) {
+	if desc := s.pc.PendingLocalDescription(); desc != nil && s.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		return webrtc.SessionDescription{Type: desc.Type, SDP: desc.SDP}, true, nil
+	}
+
+	s.negMu.Lock()
+	restartICE := s.restartICE
+	s.negMu.Unlock()
+
+	offer, err = s.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: restartICE})
+	if err != nil {
+		return webrtc.SessionDescription{}, false, err
+	}
+	if err := s.pc.SetLocalDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, false, err
+	}
+
+	if restartICE {
+		s.negMu.Lock()
+		s.restartICE = false
+		s.negMu.Unlock()
+	}
+
+	timeout := time.Duration(s.peer.session.sfu.config.WebRTC.Timeouts.SubscriberConnect) * time.Second
+	s.pc.startConnectTimer(timeout, func() {
+		slog.Info("[Subscriber] Connection did not connect in time", slog.String("peerID", s.peer.id))
+		s.peer.session.removeFailedPeer(s.peer, peerLeftTimeout, "subscriber", s.pc.ConnectionState())
+	})
+	return offer, false, nil
+}
+
+// awaitAnswer waits for the client to answer an offer request. An offer that is
+// not answered in time is sent again, up to signal.requestretries times. An offer
+// that is rejected or never answered is sent again on the next negotiation, and
+// a new offer follows once it is answered.
+func (s *Subscriber) awaitAnswer(ctx context.Context, id uint64, offer webrtc.SessionDescription) {
+	retries := s.peer.session.sfu.config.Signal.RequestRetries
+
+	for attempt := 0; ; attempt++ {
+		var result offerResult
+		err := s.peer.awaitResponse(ctx, id, &result)
+		switch {
+		case err == nil:
+			if err := s.HandleAnswer(result.Answer); err != nil {
+				s.offerFailed(err)
+			}
+			return
+		case errors.Is(err, context.Canceled), errors.Is(err, ErrPeerNotFound):
+			// Answered with the answer method, or the peer is gone
+			return
+		case errors.Is(err, ErrRequestTimeout) && attempt < retries:
+			slog.Warn("[Subscriber] Offer not answered, sending it again",
+				slog.String("peerID", s.peer.id),
+				slog.Int("attempt", attempt+2),
+			)
+			s.pc.signalingMu.Lock()
+			id, err = s.peer.sendRequest("offer", map[string]any{"offer": offer})
+			s.pc.signalingMu.Unlock()
+			if err != nil {
+				s.offerFailed(err)
+				return
+			}
+		default:
+			s.offerFailed(err)
+			return
+		}
+	}
+}
+
+// offerFailed ends a negotiation whose offer was rejected or not answered.
+// pion cannot roll back a local offer, so the offer stays pending and is sent
+// again by the next negotiation. needsOffer is set, so that the changes made
+// since are offered as soon as the client answers it.
+func (s *Subscriber) offerFailed(err error) {
+	slog.Warn("[Subscriber] Offer failed", slog.String("peerID", s.peer.id), slog.String("error", err.Error()))
+
+	s.negMu.Lock()
+	s.negotiating = false
+	s.needsOffer = true
+	s.cancelOffer = nil
+	s.negMu.Unlock()
 }
 
-// HandleAnswer processes an SDP answer from the client.
+// resumeNegotiation sends the offer again after the peer resumed, if it could
+// not be sent or answered while the peer was disconnected.
+func (s *Subscriber) resumeNegotiation() {
+	s.negMu.Lock()
+	idle := !s.negotiating
+	needsOffer := s.needsOffer
+	s.negMu.Unlock()
+
+	if idle && (needsOffer || s.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer) {
+		if err := s.Negotiate(); err != nil {
+			slog.Warn("renegotiation failed", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// HandleAnswer processes an SDP answer from the client. The answer is normally
+// the response to the offer request; the answer method is kept for clients that
+// send it separately.
 func (s *Subscriber) HandleAnswer(answer webrtc.SessionDescription) error {
 	slog.Debug("[Subscriber] Setting remote description")
 
-	if err := s.pc.SetRemoteDescription(answer); err != nil {
+	s.pc.signalingMu.Lock()
+	err := s.pc.setRemoteDescription(answer)
+	s.pc.signalingMu.Unlock()
+	if err != nil {
 		return err
 	}
 
@@ -217,8 +480,14 @@ func (s *Subscriber) HandleAnswer(answer webrtc.SessionDescription) error {
 	s.negotiating = false
 	needsOffer := s.needsOffer
 	s.needsOffer = false
+	cancel := s.cancelOffer
+	s.cancelOffer = nil
 	s.negMu.Unlock()
 
+	if cancel != nil {
+		cancel()
+	}
+
 	if needsOffer {
 		slog.Debug("[Subscriber] Pending negotiation, triggering renegotiation")
 		go func() {
@@ -275,6 +544,13 @@ func (s *Subscriber) Close() error {
 	}
 	s.mu.Unlock()
 
+	s.negMu.Lock()
+	if s.cancelOffer != nil {
+		s.cancelOffer()
+		s.cancelOffer = nil
+	}
+	s.negMu.Unlock()
+
 	for _, router := range routers {
 		router.Unsubscribe(s)
 	}
@@ -0,0 +1,143 @@
+package sfu
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// readOffer reads an offer request from the server.
+func (c *testClient) readOffer() (uint64, webrtc.SessionDescription) {
+	c.t.Helper()
+
+	msg := c.readMethod("offer")
+	data, err := json.Marshal(msg.Params["offer"])
+	if err != nil {
+		c.t.Fatalf("marshal offer: %v", err)
+	}
+	var offer webrtc.SessionDescription
+	if err := json.Unmarshal(data, &offer); err != nil {
+		c.t.Fatalf("decode offer: %v", err)
+	}
+	return msg.ID, offer
+}
+
+// answerOffers returns a function that answers the server's offers with one client connection.
+func answerOffers(t *testing.T) func(webrtc.SessionDescription) webrtc.SessionDescription {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	return func(offer webrtc.SessionDescription) webrtc.SessionDescription {
+		t.Helper()
+
+		if err := pc.SetRemoteDescription(offer); err != nil {
+			t.Fatalf("SetRemoteDescription: %v", err)
+		}
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			t.Fatalf("CreateAnswer: %v", err)
+		}
+		if err := pc.SetLocalDescription(answer); err != nil {
+			t.Fatalf("SetLocalDescription: %v", err)
+		}
+		return answer
+	}
+}
+
+// respondAnswer delivers an answer to an offer request as the client's response.
+func respondAnswer(t *testing.T, peer *Peer, id uint64, answer webrtc.SessionDescription) {
+	t.Helper()
+
+	result, err := json.Marshal(offerResult{Answer: answer})
+	if err != nil {
+		t.Fatalf("marshal answer: %v", err)
+	}
+	peer.handleResponse(&rpcClientResponse{ID: id, Result: result})
+}
+
+// sdpOrigin returns the origin line of a description, which changes with every new offer.
+func sdpOrigin(desc webrtc.SessionDescription) string {
+	for line := range strings.Lines(desc.SDP) {
+		if strings.HasPrefix(line, "o=") {
+			return line
+		}
+	}
+	return ""
+}
+
+// negotiationState returns whether a negotiation is running and whether another offer is needed.
+func (s *Subscriber) negotiationState() (negotiating, needsOffer bool) {
+	s.negMu.Lock()
+	defer s.negMu.Unlock()
+	return s.negotiating, s.needsOffer
+}
+
+func TestSubscriberOfferFailedThenReoffer(t *testing.T) {
+	tests := []struct {
+		name string
+		// reject responds to the offer with an error, otherwise it is not answered
+		reject bool
+	}{
+		{"rejected", true},
+		{"not answered", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSFU(t, func(c *Config) {
+				c.Signal.RequestTimeout = 1
+				c.Signal.RequestRetries = 0
+			})
+			session := s.GetOrCreateSession("room")
+			conn, client := newTestConn(t)
+			peer := addTestPeer(t, session, "alice", conn)
+			subscriber := peer.getSubscriber()
+			answer := answerOffers(t)
+
+			if err := subscriber.Negotiate(); err != nil {
+				t.Fatalf("Negotiate: %v", err)
+			}
+			id, failed := client.readOffer()
+			if tt.reject {
+				peer.handleResponse(&rpcClientResponse{ID: id, Error: &rpcError{Code: JSONRPCServerError, Message: "rejected"}})
+			}
+
+			waitFor(t, "the offer to fail", func() bool {
+				negotiating, _ := subscriber.negotiationState()
+				return !negotiating
+			})
+			if _, needsOffer := subscriber.negotiationState(); !needsOffer {
+				t.Fatal("needsOffer not set after the failed offer")
+			}
+
+			// The next negotiation sends the pending offer again
+			if err := subscriber.Negotiate(); err != nil {
+				t.Fatalf("Negotiate: %v", err)
+			}
+			id, offer := client.readOffer()
+			if sdpOrigin(offer) != sdpOrigin(failed) {
+				t.Fatal("pending offer replaced before it was answered")
+			}
+			respondAnswer(t, peer, id, answer(offer))
+
+			// and a new offer follows once it is answered
+			id, offer = client.readOffer()
+			if sdpOrigin(offer) == sdpOrigin(failed) {
+				t.Fatal("failed offer sent again after it was answered")
+			}
+			respondAnswer(t, peer, id, answer(offer))
+
+			waitFor(t, "the negotiation to end", func() bool {
+				negotiating, needsOffer := subscriber.negotiationState()
+				return !negotiating && !needsOffer && subscriber.pc.SignalingState() == webrtc.SignalingStateStable
+			})
+		})
+	}
+}
@@ -1,11 +1,19 @@
 package sfu
 
 import (
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
 )
 
+// writeWait is the time allowed to write a message to a WebSocket connection.
+const writeWait = 10 * time.Second
+
 // wsConn wraps a WebSocket connection with thread-safe write operations.
 type wsConn struct {
 	conn *websocket.Conn
@@ -17,9 +25,13 @@ func newWSConn(conn *websocket.Conn) *wsConn {
 }
 
 // WriteMessage writes a message to the WebSocket connection in a thread-safe manner.
+// A client that does not read fails the write after writeWait.
 func (w *wsConn) WriteMessage(messageType int, data []byte) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	if err := w.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
 	return w.conn.WriteMessage(messageType, data)
 }
 
@@ -32,3 +44,113 @@ func (w *wsConn) ReadMessage() (int, []byte, error) {
 func (w *wsConn) Close() error {
 	return w.conn.Close()
 }
+
+// maxPendingCandidates limits the remote candidates queued before the remote description.
+const maxPendingCandidates = 100
+
+// peerConnection is a WebRTC peer connection used by a publisher, a subscriber
+// or, in single mode, both of them.
+type peerConnection struct {
+	*webrtc.PeerConnection
+	// signalingMu serializes changes to the signaling state, so that offers
+	// and answers from both sides of a shared connection never interleave
+	signalingMu sync.Mutex
+	// shared is set when the publisher and the subscriber use this connection
+	shared bool
+	// pendingCandidates are the remote candidates received before the remote
+	// description. They are guarded by signalingMu.
+	pendingCandidates []webrtc.ICECandidateInit
+	// connectTimer removes the peer if the connection does not connect in
+	// time. It is guarded by signalingMu.
+	connectTimer *time.Timer
+	// connected is set once the connection has connected
+	connected atomic.Bool
+}
+
+func newPeerConnection(sfu *SFU, shared bool) (*peerConnection, error) {
+	pc, err := sfu.NewPeerConnection()
+	if err != nil {
+		return nil, err
+	}
+	return &peerConnection{PeerConnection: pc, shared: shared}, nil
+}
+
+// addICECandidate adds a remote candidate, or queues it until the remote
+// description is set. An empty candidate marks the end of the candidates.
+func (pc *peerConnection) addICECandidate(candidate webrtc.ICECandidateInit) error {
+	pc.signalingMu.Lock()
+	defer pc.signalingMu.Unlock()
+
+	if pc.RemoteDescription() != nil {
+		return pc.AddICECandidate(candidate)
+	}
+	if len(pc.pendingCandidates) >= maxPendingCandidates {
+		return ErrTooManyCandidates
+	}
+	pc.pendingCandidates = append(pc.pendingCandidates, candidate)
+	return nil
+}
+
+// setRemoteDescription sets the remote description and adds the candidates
+// queued before it. signalingMu must be held.
+func (pc *peerConnection) setRemoteDescription(desc webrtc.SessionDescription) error {
+	if err := pc.SetRemoteDescription(desc); err != nil {
+		return err
+	}
+
+	candidates := pc.pendingCandidates
+	pc.pendingCandidates = nil
+	for _, candidate := range candidates {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			slog.Warn("queued candidate rejected", slog.String("candidate", candidate.Candidate), slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+// iceUfrag returns the first ICE username fragment of an SDP. A new one in an
+// offer restarts ICE.
+func iceUfrag(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if ufrag, ok := strings.CutPrefix(strings.TrimSpace(line), "a=ice-ufrag:"); ok {
+			return ufrag
+		}
+	}
+	return ""
+}
+
+// startConnectTimer calls onTimeout if the connection has not connected within
+// timeout. Only the first call starts the timer, unless resetConnectTimer is
+// called, and a zero timeout disables it. signalingMu must be held.
+func (pc *peerConnection) startConnectTimer(timeout time.Duration, onTimeout func()) {
+	if timeout <= 0 || pc.connectTimer != nil {
+		return
+	}
+	pc.connectTimer = time.AfterFunc(timeout, func() {
+		if !pc.connected.Load() {
+			onTimeout()
+		}
+	})
+}
+
+// resetConnectTimer stops the connect timer and forgets that the connection
+// connected, so that the next startConnectTimer waits for the connection again,
+// for example after an ICE restart. signalingMu must be held.
+func (pc *peerConnection) resetConnectTimer() {
+	if pc.connectTimer != nil {
+		pc.connectTimer.Stop()
+		pc.connectTimer = nil
+	}
+	pc.connected.Store(false)
+}
+
+// Close stops the connect timer, so that a closed connection is not reported
+// as a timeout, and closes the connection.
+func (pc *peerConnection) Close() error {
+	pc.signalingMu.Lock()
+	if pc.connectTimer != nil {
+		pc.connectTimer.Stop()
+	}
+	pc.signalingMu.Unlock()
+	return pc.PeerConnection.Close()
+}
@@ -0,0 +1,108 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// newTestPeerConnection creates a peer connection that is closed when the test ends.
+func newTestPeerConnection(t *testing.T) *peerConnection {
+	t.Helper()
+
+	pc, err := newPeerConnection(newTestSFU(t, nil), false)
+	if err != nil {
+		t.Fatalf("newPeerConnection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	return pc
+}
+
+// testCandidate returns a host candidate for the first media section.
+func testCandidate(port int) webrtc.ICECandidateInit {
+	mid := "0"
+	return webrtc.ICECandidateInit{
+		Candidate: fmt.Sprintf("candidate:%d 1 udp 2130706431 192.0.2.1 %d typ host", port, port),
+		SDPMid:    &mid,
+	}
+}
+
+func TestAddICECandidateQueuesUntilRemoteDescription(t *testing.T) {
+	pc := newTestPeerConnection(t)
+
+	candidates := []webrtc.ICECandidateInit{
+		testCandidate(5000),
+		testCandidate(5001),
+		// The end of the candidates is queued like a candidate
+		{},
+	}
+	for _, candidate := range candidates {
+		if err := pc.addICECandidate(candidate); err != nil {
+			t.Fatalf("addICECandidate(%q): %v", candidate.Candidate, err)
+		}
+	}
+	if got := len(pc.pendingCandidates); got != len(candidates) {
+		t.Fatalf("queued candidates = %d, want %d", got, len(candidates))
+	}
+
+	pc.signalingMu.Lock()
+	err := pc.setRemoteDescription(clientOffer(t))
+	pc.signalingMu.Unlock()
+	if err != nil {
+		t.Fatalf("setRemoteDescription: %v", err)
+	}
+	if len(pc.pendingCandidates) != 0 {
+		t.Fatalf("queued candidates = %d after the remote description", len(pc.pendingCandidates))
+	}
+
+	// Once the remote description is set, candidates are added right away
+	if err := pc.addICECandidate(testCandidate(5002)); err != nil {
+		t.Fatalf("addICECandidate: %v", err)
+	}
+	if err := pc.addICECandidate(webrtc.ICECandidateInit{}); err != nil {
+		t.Fatalf("addICECandidate(end of candidates): %v", err)
+	}
+	if len(pc.pendingCandidates) != 0 {
+		t.Fatal("candidate queued after the remote description")
+	}
+}
+
+func TestAddICECandidateLimit(t *testing.T) {
+	pc := newTestPeerConnection(t)
+
+	for i := range maxPendingCandidates {
+		if err := pc.addICECandidate(testCandidate(5000 + i)); err != nil {
+			t.Fatalf("addICECandidate %d: %v", i, err)
+		}
+	}
+	if err := pc.addICECandidate(testCandidate(6000)); !errors.Is(err, ErrTooManyCandidates) {
+		t.Fatalf("addICECandidate over the limit = %v, want ErrTooManyCandidates", err)
+	}
+	if err := pc.addICECandidate(webrtc.ICECandidateInit{}); !errors.Is(err, ErrTooManyCandidates) {
+		t.Fatalf("end of candidates over the limit = %v, want ErrTooManyCandidates", err)
+	}
+	if got := len(pc.pendingCandidates); got != maxPendingCandidates {
+		t.Fatalf("queued candidates = %d, want %d", got, maxPendingCandidates)
+	}
+}
+
+func TestCloseStopsConnectTimer(t *testing.T) {
+	pc := newTestPeerConnection(t)
+
+	fired := make(chan struct{})
+	pc.signalingMu.Lock()
+	pc.startConnectTimer(100*time.Millisecond, func() { close(fired) })
+	pc.signalingMu.Unlock()
+	if err := pc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case <-fired:
+		t.Fatal("connect timer fired after the connection was closed")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
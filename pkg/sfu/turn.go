@@ -0,0 +1,245 @@
+package sfu
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/turn/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// turnCredentialLifetime is the lifetime of long-term credentials generated from the auth secret.
+	turnCredentialLifetime = 24 * time.Hour
+)
+
+// turnCredential is a static TURN username/password pair.
+type turnCredential struct {
+	username string
+	password string
+}
+
+// turnServer is the TURN server embedded in the SFU.
+type turnServer struct {
+	server      *turn.Server
+	config      TurnConfig
+	relayIP     net.IP
+	port        int
+	credentials []turnCredential
+}
+
+func newTurnServer(config TurnConfig, nat1to1 []string) (*turnServer, error) {
+	host, portStr, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("turn.address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("turn.address: invalid port %q", portStr)
+	}
+
+	relayIP, err := turnRelayIP(host, nat1to1)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := parseTurnCredentials(config.Auth.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &turnServer{
+		config:      config,
+		relayIP:     relayIP,
+		port:        port,
+		credentials: credentials,
+	}
+
+	udpListener, err := net.ListenPacket("udp4", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("turn: listen udp: %w", err)
+	}
+
+	tcpListener, err := t.listenTCP()
+	if err != nil {
+		_ = udpListener.Close()
+		return nil, err
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       config.Realm,
+		AuthHandler: t.authHandler(),
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:            udpListener,
+				RelayAddressGenerator: t.relayAddressGenerator(),
+			},
+		},
+		ListenerConfigs: []turn.ListenerConfig{
+			{
+				Listener:              tcpListener,
+				RelayAddressGenerator: t.relayAddressGenerator(),
+			},
+		},
+	})
+	if err != nil {
+		_ = udpListener.Close()
+		_ = tcpListener.Close()
+		return nil, err
+	}
+	t.server = server
+
+	return t, nil
+}
+
+// listenTCP listens for TURN over TCP, or TLS if a certificate is configured.
+func (t *turnServer) listenTCP() (net.Listener, error) {
+	if !t.useTLS() {
+		listener, err := net.Listen("tcp4", t.config.Address)
+		if err != nil {
+			return nil, fmt.Errorf("turn: listen tcp: %w", err)
+		}
+		return listener, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.config.Cert, t.config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("turn: load certificate: %w", err)
+	}
+
+	listener, err := tls.Listen("tcp4", t.config.Address, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("turn: listen tls: %w", err)
+	}
+	return listener, nil
+}
+
+func (t *turnServer) useTLS() bool {
+	return t.config.Cert != "" && t.config.Key != ""
+}
+
+// authHandler returns the auth handler for the configured credentials.
+// The auth secret takes precedence over static credentials.
+func (t *turnServer) authHandler() turn.AuthHandler {
+	if t.config.Auth.Secret != "" {
+		return turn.NewLongTermAuthHandler(t.config.Auth.Secret, nil)
+	}
+
+	keys := make(map[string][]byte, len(t.credentials))
+	for _, c := range t.credentials {
+		keys[c.username] = turn.GenerateAuthKey(c.username, t.config.Realm, c.password)
+	}
+
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		key, ok := keys[username]
+		return key, ok
+	}
+}
+
+func (t *turnServer) relayAddressGenerator() turn.RelayAddressGenerator {
+	if len(t.config.PortRange) == 2 {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: t.relayIP,
+			Address:      "0.0.0.0",
+			MinPort:      t.config.PortRange[0],
+			MaxPort:      t.config.PortRange[1],
+		}
+	}
+
+	return &turn.RelayAddressGeneratorStatic{
+		RelayAddress: t.relayIP,
+		Address:      "0.0.0.0",
+	}
+}
+
+// shareable reports whether the ICE server entry may be handed to clients.
+// Generated credentials expire and are per client, while a static credential is
+// the same for everyone and is only sent when the config allows it.
+func (t *turnServer) shareable() bool {
+	return t.config.Auth.Secret != "" || t.config.Auth.ShareCredentials
+}
+
+// ICEServer returns an ICE server entry pointing at the embedded TURN server.
+// It carries new long-term credentials if an auth secret is set, otherwise the
+// first static credential.
+func (t *turnServer) ICEServer() (webrtc.ICEServer, error) {
+	hostPort := net.JoinHostPort(t.relayIP.String(), strconv.Itoa(t.port))
+
+	urls := []string{"turn:" + hostPort + "?transport=udp"}
+	if t.useTLS() {
+		urls = append(urls, "turns:"+hostPort+"?transport=tcp")
+	} else {
+		urls = append(urls, "turn:"+hostPort+"?transport=tcp")
+	}
+
+	if t.config.Auth.Secret != "" {
+		username, password, err := turn.GenerateLongTermCredentials(t.config.Auth.Secret, turnCredentialLifetime)
+		if err != nil {
+			return webrtc.ICEServer{}, err
+		}
+		return webrtc.ICEServer{URLs: urls, Username: username, Credential: password}, nil
+	}
+
+	credential := t.credentials[0]
+	return webrtc.ICEServer{URLs: urls, Username: credential.username, Credential: credential.password}, nil
+}
+
+// Close stops the TURN server.
+func (t *turnServer) Close() error {
+	return t.server.Close()
+}
+
+// turnRelayIP determines the IP advertised for relayed candidates.
+// It prefers the first 1:1 NAT address, then the TURN listen address, then
+// the first non-loopback IPv4 interface address.
+func turnRelayIP(host string, nat1to1 []string) (net.IP, error) {
+	if len(nat1to1) > 0 {
+		return net.ParseIP(nat1to1[0]), nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return ip, nil
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, errors.New("turn: no relay address available, set webrtc.candidates.nat1to1")
+}
+
+// parseTurnCredentials parses "user=password,user2=password2".
+func parseTurnCredentials(value string) ([]turnCredential, error) {
+	var credentials []turnCredential
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		username, password, ok := strings.Cut(pair, "=")
+		if !ok || username == "" || password == "" {
+			return nil, fmt.Errorf("turn.auth.credentials: invalid pair %q", pair)
+		}
+		credentials = append(credentials, turnCredential{username: username, password: password})
+	}
+	return credentials, nil
+}
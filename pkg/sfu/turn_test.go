@@ -0,0 +1,112 @@
+package sfu
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseTurnCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []turnCredential
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"one pair", "pion=ion", []turnCredential{{"pion", "ion"}}, false},
+		{"pairs with spaces", "pion=ion, pion2=ion2 ,", []turnCredential{{"pion", "ion"}, {"pion2", "ion2"}}, false},
+		{"missing password", "pion=", nil, true},
+		{"missing separator", "pion", nil, true},
+		{"missing username", "=ion", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTurnCredentials(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("credentials = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("credentials = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTurnICEServersForClients(t *testing.T) {
+	static := []turnCredential{{"pion", "ion"}}
+
+	tests := []struct {
+		name string
+		auth TurnAuthConfig
+		// wantClient is whether clients get the TURN server
+		wantClient bool
+		// wantStatic is whether the entry carries the static credential
+		wantStatic bool
+	}{
+		{"secret", TurnAuthConfig{Secret: "secret"}, true, false},
+		{"secret and credentials", TurnAuthConfig{Secret: "secret", Credentials: "pion=ion"}, true, false},
+		{"credentials only", TurnAuthConfig{Credentials: "pion=ion"}, false, true},
+		{"shared credentials", TurnAuthConfig{Credentials: "pion=ion", ShareCredentials: true}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SFU{turn: &turnServer{
+				config:      TurnConfig{Realm: "ion", Auth: tt.auth},
+				relayIP:     net.ParseIP("203.0.113.10"),
+				port:        3478,
+				credentials: static,
+			}}
+
+			own, err := s.iceServers(false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(own) != 1 {
+				t.Fatalf("SFU ICE servers = %+v, want the TURN server", own)
+			}
+			if got := own[0].Username == "pion"; got != tt.wantStatic {
+				t.Fatalf("username = %q, static %v", own[0].Username, tt.wantStatic)
+			}
+
+			clients, err := s.ICEServers()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := len(clients) == 1; got != tt.wantClient {
+				t.Fatalf("client ICE servers = %+v, want TURN server %v", clients, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestTurnGeneratedCredentials(t *testing.T) {
+	server := &turnServer{
+		config:  TurnConfig{Realm: "ion", Auth: TurnAuthConfig{Secret: "secret"}},
+		relayIP: net.ParseIP("203.0.113.10"),
+		port:    3478,
+	}
+
+	first, err := server.ICEServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Username == "" || first.Credential == "" {
+		t.Fatalf("ICE server = %+v, want credentials", first)
+	}
+	if len(first.URLs) != 2 || first.URLs[0] != "turn:203.0.113.10:3478?transport=udp" {
+		t.Fatalf("urls = %v", first.URLs)
+	}
+}